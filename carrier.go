@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"github.com/sergeykochiev/tgsh/png"
+	"github.com/sergeykochiev/tgsh/webp"
+)
+
+// a way of storing a chunk of a file in a sticker
+type Carrier interface {
+	// recorded in header entries to pick the decoder when reading
+	Id() string
+	// payload bytes that fit into a sticker of the given size
+	Capacity(w, h int) int
+	// returns the file to upload for the target and the sticker format to upload it as
+	Encode(payload []byte, t CarrierTarget) ([]byte, string, error)
+	// takes the file as downloaded from Telegram
+	Decode(fileData []byte) ([]byte, error)
+}
+
+// the sticker a payload is encoded into
+type CarrierTarget struct {
+	Width int
+	Height int
+	// lines to show on the sticker, where the carrier has room for them
+	Label []string
+}
+
+// entries written before carriers were recorded use the alpha one
+func newCarrier(id string, format string) (Carrier, error) {
+	switch format {
+	case "", UploadFormatWebp, UploadFormatPng:
+	default:
+		return nil, fmt.Errorf("unknown upload format \"%s\", use %s or %s", format, UploadFormatWebp, UploadFormatPng)
+	}
+	switch id {
+	case "", CarrierAlpha:
+		return alphaCarrier{ png: format == UploadFormatPng }, nil
+	case CarrierTgs:
+		return tgsCarrier{}, nil
+	case CarrierCover:
+		// enough for decoding, encoding needs a cover set with WithCover
+		return coverCarrier{ png: format == UploadFormatPng }, nil
+	default:
+		return nil, fmt.Errorf("unknown carrier \"%s\"", id)
+	}
+}
+
+// payload bytes go into the alpha channel of an otherwise black static sticker
+type alphaCarrier struct {
+	// upload png and leave the conversion to webp to Telegram
+	png bool
+}
+
+func (c alphaCarrier) Id() string {
+	return CarrierAlpha
+}
+
+func (c alphaCarrier) Capacity(w, h int) int {
+	return w * h - FrameHeaderLength
+}
+
+// regular stickers only need one side to be 512 pixels, so their images are only
+// as tall as the payload and the label need, while custom emoji have to be exact
+func carrierImage(data []byte, t CarrierTarget) (*image.NRGBA, error) {
+	framed := frameData(data)
+	if len(framed) > t.Width * t.Height {
+		return nil, fmt.Errorf("%d bytes do not fit into one sticker", len(data))
+	}
+	height := t.Height
+	if t.Width == StickerSide {
+		height = min(t.Height, max(1, (len(framed) + t.Width - 1) / t.Width + labelHeight(t.Label)))
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, t.Width, height))
+	for i, b := range(framed) {
+		img.Pix[i * 4 + 3] = b
+	}
+	drawLabel(img, len(framed), t.Label)
+	return img, nil
+}
+
+// alpha of every pixel, row by row, as written by carrierImage
+func carrierPayload(img *image.NRGBA) []byte {
+	w := img.Rect.Dx()
+	h := img.Rect.Dy()
+	output := make([]byte, 0, w * h)
+	for y := range(h) {
+		row := img.Pix[y * img.Stride:]
+		for x := range(w) {
+			output = append(output, row[x * 4 + 3])
+		}
+	}
+	return output
+}
+
+func (c alphaCarrier) encodePng(img *image.NRGBA) ([]byte, error) {
+	var p png.PngImage
+	p.ImageData = make([]byte, 0, len(img.Pix) / 2)
+	// the label is drawn in gray, so the red channel is enough
+	for i := 0; i < len(img.Pix); i += 4 {
+		p.ImageData = append(p.ImageData, img.Pix[i], img.Pix[i + 3])
+	}
+	err := p.Configure(uint32(img.Rect.Dx()), uint32(img.Rect.Dy()), png.PngCT_GrayscaleAlpha, 8)
+	if err != nil {
+		return nil, err
+	}
+	err = p.SetCompressionLevel(zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	return p.Encode(), nil
+}
+
+// webp is what Telegram stores static stickers as, so uploading it directly
+// lets the sticker be read back locally exactly as it will be downloaded
+func encodeVerifiedWebp(c Carrier, img *image.NRGBA, data []byte) ([]byte, error) {
+	encoded, err := webp.Encode(img)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := c.Decode(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("verify encoded sticker: %s", err)
+	}
+	if !bytes.Equal(decoded, data) {
+		return nil, errors.New("verify encoded sticker: payload does not survive a round trip")
+	}
+	return encoded, nil
+}
+
+func (c alphaCarrier) Encode(payload []byte, t CarrierTarget) ([]byte, string, error) {
+	img, err := carrierImage(payload, t)
+	if err != nil {
+		return nil, "", err
+	}
+	var encoded []byte
+	if c.png {
+		encoded, err = c.encodePng(img)
+	} else {
+		encoded, err = encodeVerifiedWebp(c, img, payload)
+	}
+	return encoded, StickerFormatStatic, err
+}
+
+func (c alphaCarrier) Decode(fileData []byte) ([]byte, error) {
+	img, err := webp.Decode(fileData)
+	if err != nil {
+		return nil, err
+	}
+	return unframeData(carrierPayload(img)), nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"os"
+	"golang.org/x/image/draw"
+	"github.com/sergeykochiev/tgsh/png"
+	"github.com/sergeykochiev/tgsh/webp"
+)
+
+func init() {
+	// covers may be PNG
+	png.Register()
+}
+
+// payload goes into the low bits of the color channels of a cover image,
+// using only opaque pixels since converters are free to change the color
+// of transparent ones
+type coverCarrier struct {
+	cover image.Image
+	png bool
+}
+
+func loadCover(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("decode cover: %s", err)
+	}
+	return img, nil
+}
+
+func (c coverCarrier) Id() string {
+	return CarrierCover
+}
+
+// the cover is scaled to fit regular stickers keeping its aspect ratio,
+// and stretched to custom emoji, which have to be exactly w by h
+func (c coverCarrier) scaledCover(w, h int) *image.NRGBA {
+	b := c.cover.Bounds()
+	if w == StickerSide && b.Dx() > 0 && b.Dy() > 0 {
+		if b.Dx() >= b.Dy() {
+			h = max(1, (b.Dy() * w + b.Dx() / 2) / b.Dx())
+		} else {
+			w = max(1, (b.Dx() * h + b.Dy() / 2) / b.Dy())
+		}
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(img, img.Rect, c.cover, b, draw.Src, nil)
+	return img
+}
+
+func opaquePixels(img *image.NRGBA) int {
+	n := 0
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] == 0xFF {
+			n++
+		}
+	}
+	return n
+}
+
+func (c coverCarrier) Capacity(w, h int) int {
+	if c.cover == nil {
+		return 0
+	}
+	return max(0, opaquePixels(c.scaledCover(w, h)) * 3 * CoverBits / 8 - FrameHeaderLength)
+}
+
+// no label is drawn, as the point of a cover is to look like a normal sticker
+func (c coverCarrier) Encode(payload []byte, t CarrierTarget) ([]byte, string, error) {
+	if c.cover == nil {
+		return nil, "", fmt.Errorf("carrier \"%s\" needs a cover image", CarrierCover)
+	}
+	img := c.scaledCover(t.Width, t.Height)
+	framed := frameData(payload)
+	if len(framed) * 8 > opaquePixels(img) * 3 * CoverBits {
+		return nil, "", fmt.Errorf("%d bytes do not fit into the cover", len(payload))
+	}
+	mask := byte(1 << CoverBits - 1)
+	var acc uint32
+	var n int
+	next := 0
+	for i := 0; i < len(img.Pix) && (next < len(framed) || n > 0); i += 4 {
+		if img.Pix[i + 3] != 0xFF {
+			continue
+		}
+		for ch := range(3) {
+			if n < CoverBits && next < len(framed) {
+				acc |= uint32(framed[next]) << n
+				n += 8
+				next++
+			}
+			img.Pix[i + ch] = img.Pix[i + ch] &^ mask | byte(acc) & mask
+			acc >>= CoverBits
+			n = max(0, n - CoverBits)
+		}
+	}
+	if c.png {
+		p, err := png.FromImage(img)
+		if err != nil {
+			return nil, "", err
+		}
+		return p.Encode(), StickerFormatStatic, nil
+	}
+	encoded, err := encodeVerifiedWebp(c, img, payload)
+	return encoded, StickerFormatStatic, err
+}
+
+func (c coverCarrier) Decode(fileData []byte) ([]byte, error) {
+	img, err := webp.Decode(fileData)
+	if err != nil {
+		return nil, err
+	}
+	mask := uint32(1 << CoverBits - 1)
+	output := make([]byte, 0, opaquePixels(img) * 3 * CoverBits / 8)
+	var acc uint32
+	var n int
+	for i := 0; i < len(img.Pix); i += 4 {
+		if img.Pix[i + 3] != 0xFF {
+			continue
+		}
+		for ch := range(3) {
+			acc |= (uint32(img.Pix[i + ch]) & mask) << n
+			n += CoverBits
+			if n >= 8 {
+				output = append(output, byte(acc))
+				acc >>= 8
+				n -= 8
+			}
+		}
+	}
+	return unframeData(output), nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type lottieValue struct {
+	Animated int `json:"a"`
+	Value any `json:"k"`
+}
+
+func lottieStatic(v any) lottieValue {
+	return lottieValue{ Value: v }
+}
+
+type lottieTransform struct {
+	Opacity lottieValue `json:"o"`
+	Rotation lottieValue `json:"r"`
+	Position lottieValue `json:"p"`
+	Anchor lottieValue `json:"a"`
+	Scale lottieValue `json:"s"`
+}
+
+type lottieLayer struct {
+	ThreeD int `json:"ddd"`
+	Index int `json:"ind"`
+	Type int `json:"ty"`
+	Name string `json:"nm"`
+	Transform lottieTransform `json:"ks"`
+	Shapes []map[string]any `json:"shapes,omitempty"`
+	AutoOrient int `json:"ao"`
+	InPoint int `json:"ip"`
+	OutPoint int `json:"op"`
+	StartTime int `json:"st"`
+	BlendMode int `json:"bm"`
+}
+
+type lottieDocument struct {
+	Tgs int `json:"tgs"`
+	Version string `json:"v"`
+	FrameRate int `json:"fr"`
+	InPoint int `json:"ip"`
+	OutPoint int `json:"op"`
+	Width int `json:"w"`
+	Height int `json:"h"`
+	Name string `json:"nm"`
+	ThreeD int `json:"ddd"`
+	Assets []any `json:"assets"`
+	Layers []lottieLayer `json:"layers"`
+}
+
+func lottieIdentity(opacity int) lottieTransform {
+	return lottieTransform{
+		Opacity: lottieStatic(opacity),
+		Rotation: lottieStatic(0),
+		Position: lottieStatic([]int{ StickerSide / 2, StickerSide / 2, 0 }),
+		Anchor: lottieStatic([]int{ 0, 0, 0 }),
+		Scale: lottieStatic([]int{ 100, 100, 100 }),
+	}
+}
+
+func lottieLayerOf(index int, layerType int, name string, opacity int) lottieLayer {
+	return lottieLayer{
+		Index: index,
+		Type: layerType,
+		Name: name,
+		Transform: lottieIdentity(opacity),
+		OutPoint: TgsFrameCount,
+	}
+}
+
+// payload is stored in the name of an invisible null layer, next to a square
+// that makes the sticker visible in the set
+func tgsDocument(payload []byte) lottieDocument {
+	side := StickerSide / 2
+	square := lottieLayerOf(2, LottieLayerShape, "square", 100)
+	square.Shapes = []map[string]any{
+		{ "ty": "gr", "nm": "square", "it": []map[string]any{
+			{ "ty": "rc", "p": lottieStatic([]int{ 0, 0 }), "s": lottieStatic([]int{ side, side }), "r": lottieStatic(side / 8) },
+			{ "ty": "fl", "c": lottieStatic([]float64{ 0.2, 0.6, 1, 1 }), "o": lottieStatic(100) },
+			{ "ty": "tr", "p": lottieStatic([]int{ 0, 0 }), "a": lottieStatic([]int{ 0, 0 }), "s": lottieStatic([]int{ 100, 100 }), "r": lottieStatic(0), "o": lottieStatic(100) },
+		} },
+	}
+	return lottieDocument{
+		Tgs: 1,
+		Version: "5.5.2",
+		FrameRate: TgsFrameRate,
+		OutPoint: TgsFrameCount,
+		Width: StickerSide,
+		Height: StickerSide,
+		Name: HubSignature,
+		Assets: []any{},
+		Layers: []lottieLayer{
+			lottieLayerOf(1, LottieLayerNull, TgsPayloadPrefix + base64.StdEncoding.EncodeToString(payload), 0),
+			square,
+		},
+	}
+}
+
+// payload goes into an animated sticker, which Telegram stores as uploaded
+type tgsCarrier struct {}
+
+func (c tgsCarrier) Id() string {
+	return CarrierTgs
+}
+
+// limited by the compressed file size, not the dimensions
+func (c tgsCarrier) Capacity(w, h int) int {
+	return TgsCapacity
+}
+
+// animated custom emoji use the same 512x512 canvas as stickers, so the size is ignored,
+// and so is the label since the square is what makes the sticker recognizable
+func (c tgsCarrier) Encode(payload []byte, t CarrierTarget) ([]byte, string, error) {
+	doc, err := json.Marshal(tgsDocument(payload))
+	if err != nil {
+		return nil, "", fmt.Errorf("json encode Lottie: %s", err)
+	}
+	var b bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err = zw.Write(doc); err != nil {
+		return nil, "", err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, "", err
+	}
+	if b.Len() > TgsMaxSize {
+		return nil, "", fmt.Errorf("%d bytes do not fit into one animated sticker", len(payload))
+	}
+	return b.Bytes(), StickerFormatAnimated, nil
+}
+
+func (c tgsCarrier) Decode(fileData []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(fileData))
+	if err != nil {
+		return nil, fmt.Errorf("gunzip: %s", err)
+	}
+	doc, err := io.ReadAll(io.LimitReader(r, int64(TgsMaxDocumentSize) + 1))
+	if err != nil {
+		return nil, fmt.Errorf("gunzip: %s", err)
+	}
+	if len(doc) > TgsMaxDocumentSize {
+		return nil, errors.New("animation is too large")
+	}
+	var lottie lottieDocument
+	if err = json.Unmarshal(doc, &lottie); err != nil {
+		return nil, fmt.Errorf("json decode Lottie: %s", err)
+	}
+	for _, l := range(lottie.Layers) {
+		if l.Type == LottieLayerNull && strings.HasPrefix(l.Name, TgsPayloadPrefix) {
+			return base64.StdEncoding.DecodeString(l.Name[len(TgsPayloadPrefix):])
+		}
+	}
+	return nil, errors.New("animation has no payload layer")
+}
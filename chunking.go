@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math/bits"
+)
+
+// random values for every byte, fixed so that the same content is always
+// cut in the same places, by this and by any later version
+var gearTable [256]uint64
+
+func init() {
+	// splitmix64
+	x := uint64(0x7467736863646300)
+	for i := range(gearTable) {
+		x += 0x9E3779B97F4A7C15
+		z := x
+		z = (z ^ z >> 30) * 0xBF58476D1CE4E5B9
+		z = (z ^ z >> 27) * 0x94D049BB133111EB
+		gearTable[i] = z ^ z >> 31
+	}
+}
+
+// content addresses chunks in the header
+func chunkHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:ChunkHashLength])
+}
+
+// length of the first chunk of data. cuts fall where the gear hash of the bytes
+// before them has its top bits clear, which takes more bits before the average
+// size and fewer after it, keeping chunks close to the average
+func cutPoint(data []byte, minSize int, avgSize int, maxSize int) int {
+	if len(data) <= minSize {
+		return len(data)
+	}
+	n := min(len(data), maxSize)
+	b := bits.Len(uint(avgSize)) - 1
+	strict := ^uint64(0) << (64 - b - 1)
+	loose := ^uint64(0) << (64 - b + 1)
+	var h uint64
+	i := minSize
+	for ; i < min(avgSize, n); i++ {
+		h = h << 1 + gearTable[data[i]]
+		if h & strict == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = h << 1 + gearTable[data[i]]
+		if h & loose == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// splits data into chunks of at most maxSize bytes at points that depend on
+// the content around them, so an edit only changes the chunks it touches
+func contentChunks(data []byte, maxSize int) [][]byte {
+	// larger chunks dedup worse but take fewer stickers
+	minSize := max(1, maxSize / 2)
+	avgSize := max(2, maxSize * 3 / 4)
+	chunks := [][]byte{}
+	for len(data) > 0 {
+		n := cutPoint(data, minSize, avgSize, maxSize)
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	if len(chunks) == 0 {
+		chunks = append(chunks, data)
+	}
+	return chunks
+}
+
+func chunkKey(carrier string, hash string) string {
+	return carrier + ":" + hash
+}
+
+// index into Hashes of the i-th sticker of a file, or -1 if it holds parity
+// or the file was put before content-defined chunking
+func (e StickerHubInfoEntry) dataIndex(i int) int {
+	if e.isParity(i) {
+		return -1
+	}
+	j := i
+	if e.Parity > 0 {
+		per := e.Group + e.Parity
+		j = i / per * e.Group + i % per
+	}
+	if j >= len(e.Hashes) {
+		return -1
+	}
+	return j
+}
+
+// sticker in the hub holding the chunk, for the files stored with the carrier
+func (sh StickerHub) chunkSticker(carrier string, hash string) (string, bool) {
+	record, ok := sh.chunks[chunkKey(carrier, hash)]
+	if !ok {
+		return "", false
+	}
+	if _, ok = sh.stickerByUniqueId(record.Sticker); !ok {
+		return "", false
+	}
+	return record.Sticker, true
+}
+
+// counts the chunks of a file just put into the hub, whose j-th chunk is
+// sizes[j] bytes in a sticker of pool. a sticker put in place of one that went
+// missing takes over the chunk in every file holding it
+func (sh* StickerHub) addChunkRefs(e StickerHubInfoEntry, sizes []int, pool string) {
+	if sh.chunks == nil {
+		sh.chunks = map[string]StickerHubChunk{}
+	}
+	for i, id := range(e.Stickers) {
+		j := e.dataIndex(i)
+		if j < 0 {
+			continue
+		}
+		key := chunkKey(e.Carrier, e.Hashes[j])
+		record, ok := sh.chunks[key]
+		if ok && record.Sticker != id {
+			sh.replaceReferences(record.Sticker, id)
+			record = sh.chunks[key]
+		}
+		if record.Sticker != id && pool != "" {
+			record.Pool = pool
+		}
+		record.Sticker = id
+		record.Refs++
+		if j < len(sizes) {
+			record.Size = sizes[j]
+		}
+		sh.chunks[key] = record
+	}
+}
+
+// uncounts the chunks of the idx-th file, returning the stickers it holds that
+// no file does after it is gone
+func (sh* StickerHub) dropChunkRefs(idx int) []string {
+	e := sh.info[idx]
+	var unused []string
+	seen := map[string]bool{}
+	for i, id := range(e.Stickers) {
+		if j := e.dataIndex(i); j >= 0 {
+			key := chunkKey(e.Carrier, e.Hashes[j])
+			if record, ok := sh.chunks[key]; ok {
+				record.Refs--
+				if record.Refs > 0 {
+					sh.chunks[key] = record
+				} else {
+					delete(sh.chunks, key)
+				}
+				if record.Refs > 0 && record.Sticker == id {
+					continue
+				}
+			}
+		}
+		// the header may predate the counts or have lost track of a sticker
+		if seen[id] || sh.isShared(idx, id) {
+			continue
+		}
+		seen[id] = true
+		unused = append(unused, id)
+	}
+	return unused
+}
+
+// counts the chunks of every file, for headers written before the counts were
+func (sh* StickerHub) rebuildChunks() {
+	sh.chunks = map[string]StickerHubChunk{}
+	for _, e := range(sh.info) {
+		sh.addChunkRefs(e, nil, "")
+	}
+}
+
+// points every file and chunk at sticker instead of old, which holds the same payload
+func (sh* StickerHub) replaceReferences(old string, sticker string) {
+	for k := range(sh.info) {
+		for j, id := range(sh.info[k].Stickers) {
+			if id == old {
+				sh.info[k].Stickers[j] = sticker
+			}
+		}
+	}
+	for key, record := range(sh.chunks) {
+		if record.Sticker == old {
+			record.Sticker = sticker
+			sh.chunks[key] = record
+		}
+	}
+}
+
+// whether a file other than the idx-th one holds a chunk in the sticker
+func (sh StickerHub) isShared(idx int, uniqueId string) bool {
+	for i, e := range(sh.info) {
+		if i == idx {
+			continue
+		}
+		for _, id := range(e.Stickers) {
+			if id == uniqueId {
+				return true
+			}
+		}
+	}
+	return false
+}
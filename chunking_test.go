@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"github.com/sergeykochiev/tgsh/reedsolomon"
+)
+
+func randomData(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestContentChunksBounds(t *testing.T) {
+	const maxSize = 1000
+	data := randomData(1, 200000)
+	chunks := contentChunks(data, maxSize)
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("chunks do not add up to the data")
+	}
+	for i, c := range(chunks) {
+		if len(c) > maxSize {
+			t.Errorf("chunk %d is %d bytes, over %d", i, len(c), maxSize)
+		}
+		if i < len(chunks) - 1 && len(c) < maxSize / 2 {
+			t.Errorf("chunk %d is %d bytes, under %d", i, len(c), maxSize / 2)
+		}
+	}
+	if len(chunks) < len(data) / maxSize {
+		t.Errorf("%d chunks for %d bytes", len(chunks), len(data))
+	}
+	again := contentChunks(data, maxSize)
+	if len(again) != len(chunks) {
+		t.Fatalf("%d chunks the second time, %d the first", len(again), len(chunks))
+	}
+	for i := range(chunks) {
+		if chunkHash(chunks[i]) != chunkHash(again[i]) {
+			t.Fatalf("chunk %d differs the second time", i)
+		}
+	}
+}
+
+func TestContentChunksEmpty(t *testing.T) {
+	chunks := contentChunks(nil, 100)
+	if len(chunks) != 1 || len(chunks[0]) != 0 {
+		t.Fatalf("got %d chunks for no data, want one empty chunk", len(chunks))
+	}
+}
+
+// an insertion only changes the chunks around it, the rest are reused
+func TestContentChunksInsertion(t *testing.T) {
+	const maxSize = 1000
+	data := randomData(2, 100000)
+	edited := append(append(append([]byte{}, data[:50000]...), randomData(3, 37)...), data[50000:]...)
+	hashes := map[string]bool{}
+	for _, c := range(contentChunks(data, maxSize)) {
+		hashes[chunkHash(c)] = true
+	}
+	chunks := contentChunks(edited, maxSize)
+	changed := 0
+	for _, c := range(chunks) {
+		if !hashes[chunkHash(c)] {
+			changed++
+		}
+	}
+	// chunks cut at the maximum size take a few more to fall back into step
+	if changed * 10 > len(chunks) {
+		t.Errorf("%d of %d chunks changed after a 37 byte insertion", changed, len(chunks))
+	}
+}
+
+// parity over chunks of differing sizes recovers every one of them
+func TestParityOverContentChunks(t *testing.T) {
+	e := StickerHubInfoEntry{ Size: 30000, ChunkSize: 1000, Group: 4, Parity: 2 }
+	data := randomData(4, e.Size)
+	chunks := contentChunks(data, e.ChunkSize)
+	for _, c := range(chunks) {
+		e.Hashes = append(e.Hashes, chunkHash(c))
+	}
+	payloads, err := filePayloads(e, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payloads) != e.stickerCount() {
+		t.Fatalf("%d payloads, want %d", len(payloads), e.stickerCount())
+	}
+	code, err := reedsolomon.New(e.Group, e.Parity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := 0
+	for start := 0; start < len(chunks); start += e.Group {
+		dataCount := min(e.Group, len(chunks) - start)
+		shards := make([][]byte, e.Group + e.Parity)
+		for j := range(dataCount + e.Parity) {
+			slot := j
+			if j >= dataCount {
+				slot = e.Group + j - dataCount
+			}
+			if i := e.dataIndex(next + j); i != start + j && j < dataCount {
+				t.Fatalf("sticker %d holds chunk %d, want %d", next + j, i, start + j)
+			}
+			shards[slot] = payloads[next + j]
+		}
+		next += dataCount + e.Parity
+		// lose the first and last data chunks of the group
+		shards[0] = nil
+		shards[dataCount - 1] = nil
+		if err = recoverGroup(code, shards, dataCount); err != nil {
+			t.Fatal(err)
+		}
+		for j := range(dataCount) {
+			want := chunks[start + j]
+			if !bytes.Equal(shards[j][:len(want)], want) {
+				t.Fatalf("chunk %d is not recovered", start + j)
+			}
+		}
+	}
+}
@@ -5,48 +5,306 @@ import(
 	"fmt"
 	"encoding/json"
 	"errors"
+	"path/filepath"
 )
 
-type Config struct {
+type HubOptions struct {
+	Emoji string `json:",omitempty"`
+	// carrier new files are stored with, "alpha" (default) or "tgs"
+	Carrier string `json:",omitempty"`
+	// "webp" (default) or "png", which leaves the conversion to Telegram
+	Format string `json:",omitempty"`
+	// type of sets new files go to, "regular" (default) or "custom_emoji"
+	Pool string `json:",omitempty"`
+	// parity stickers added to every group of data stickers, 0 (default) for none
+	Parity int `json:",omitempty"`
+	// data stickers in a group, 8 by default
+	ParityGroup int `json:",omitempty"`
+}
+
+// another hub holding the same files, in its own sets and possibly owned by
+// its own bot. without a token source of its own it uses the hub's one
+type ReplicaProfile struct {
+	SetName string
+	TokenEnv string `json:",omitempty"`
+	TokenFile string `json:",omitempty"`
+	TokenCommand string `json:",omitempty"`
+	Token string `json:",omitempty"`
+}
+
+type HubProfile struct {
 	UserId int
 	SetName string
+	Sets []string `json:",omitempty"`
+	TokenEnv string `json:",omitempty"`
+	TokenFile string `json:",omitempty"`
+	TokenCommand string `json:",omitempty"`
+	Token string `json:",omitempty"`
+	Options HubOptions
+	Replicas []ReplicaProfile `json:",omitempty"`
+}
+
+type Config struct {
+	Version int
+	Current string
+	Hubs map[string]*HubProfile
+	// single hub config written by versions before profiles
+	UserId int `json:",omitempty"`
+	SetName string `json:",omitempty"`
+	active string
+	path string
+}
+
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		var err error
+		dir, err = os.UserConfigDir()
+		if err != nil {
+			return LegacyConfigPath
+		}
+	}
+	return filepath.Join(dir, "tgsh", ConfigFilename)
+}
+
+func (c* Config) SetPath(path string) {
+	c.path = path
+}
+
+func (c Config) Path() string {
+	if c.path == "" {
+		return defaultConfigPath()
+	}
+	return c.path
 }
 
 func (c* Config) GetOrCreate() error {
-	ok, err := c.isFileExists()
+	ok, err := c.isFileExists(c.Path())
 	if err != nil {
 		return err
 	}
-	if !ok {
-		return c.WriteFile()
+	if ok {
+		return c.FromFile()
+	}
+	if c.path == "" {
+		ok, err = c.isFileExists(LegacyConfigPath)
+		if err != nil {
+			return err
+		}
+		if ok {
+			migrated, err := c.fromLegacyFile()
+			if err != nil || migrated {
+				return err
+			}
+		}
+	}
+	c.Version = ConfigVersion
+	return c.WriteFile()
+}
+
+// takes the config.json in the working directory, which may as well belong to
+// something else, only if it has the fields of a config and a configured hub.
+// the file itself is left in place
+func (c* Config) fromLegacyFile() (bool, error) {
+	file, err := os.OpenFile(LegacyConfigPath, os.O_RDONLY, 0644)
+	if err != nil {
+		return false, fmt.Errorf("open file: %s", err)
 	}
-	return c.FromFile()
+	defer file.Close()
+	legacy := Config{ path: c.path, active: c.active }
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if decoder.Decode(&legacy) != nil || !legacy.hasConfiguredHub() {
+		return false, nil
+	}
+	*c = legacy
+	err = c.migrate()
+	if err != nil {
+		return false, err
+	}
+	err = c.WriteFile()
+	if err != nil {
+		return false, err
+	}
+	fmt.Fprintf(os.Stderr, "Copied config from %s to %s, the old one is left in place\n", LegacyConfigPath, c.Path())
+	return true, nil
+}
+
+// whether a config as read, before migrating, sets up any hub
+func (c Config) hasConfiguredHub() bool {
+	if c.UserId != 0 && c.SetName != "" {
+		return true
+	}
+	for _, p := range(c.Hubs) {
+		if p != nil && p.IsConfigured() {
+			return true
+		}
+	}
+	return false
+}
+
+func (p HubProfile) IsConfigured() bool {
+	return p.UserId != 0 && p.SetName != ""
 }
 
-func (c Config) IsConfigured() bool {
-	return c.UserId != 0 && c.SetName != ""
+func (r ReplicaProfile) hasToken() bool {
+	return r.TokenEnv != "" || r.TokenFile != "" || r.TokenCommand != "" || r.Token != ""
 }
 
-func (c Config) isFileExists() (bool, error) {
-	_, err := os.Stat(ConfigPath)
+// profile to open a replica of the hub with
+func (p HubProfile) replicaProfile(r ReplicaProfile) *HubProfile {
+	rp := &HubProfile{
+		UserId: p.UserId,
+		SetName: r.SetName,
+		TokenEnv: p.TokenEnv,
+		TokenFile: p.TokenFile,
+		TokenCommand: p.TokenCommand,
+		Token: p.Token,
+		Options: p.Options,
+	}
+	if r.hasToken() {
+		rp.TokenEnv = r.TokenEnv
+		rp.TokenFile = r.TokenFile
+		rp.TokenCommand = r.TokenCommand
+		rp.Token = r.Token
+	}
+	return rp
+}
+
+func (c* Config) Select(name string) error {
+	if name == "" {
+		name = c.Current
+	}
+	if name == "" {
+		name = DefaultHubName
+		c.AddHub(name)
+	}
+	if _, ok := c.Hubs[name]; !ok {
+		return fmt.Errorf("no hub named \"%s\"", name)
+	}
+	c.active = name
+	return nil
+}
+
+func (c Config) Hub() *HubProfile {
+	return c.Hubs[c.active]
+}
+
+func (c Config) HubName() string {
+	return c.active
+}
+
+func (c* Config) AddHub(name string) (*HubProfile, error) {
+	if err := checkHubName(name); err != nil {
+		return nil, err
+	}
+	if _, ok := c.Hubs[name]; ok {
+		return nil, fmt.Errorf("hub \"%s\" already exists", name)
+	}
+	if c.Hubs == nil {
+		c.Hubs = map[string]*HubProfile{}
+	}
+	p := &HubProfile{}
+	c.Hubs[name] = p
+	if c.Current == "" {
+		c.Current = name
+	}
+	return p, nil
+}
+
+func (c* Config) UseHub(name string) error {
+	if _, ok := c.Hubs[name]; !ok {
+		return fmt.Errorf("no hub named \"%s\"", name)
+	}
+	c.Current = name
+	return nil
+}
+
+func (c* Config) RemoveHub(name string) error {
+	if _, ok := c.Hubs[name]; !ok {
+		return fmt.Errorf("no hub named \"%s\"", name)
+	}
+	delete(c.Hubs, name)
+	if c.Current == name {
+		c.Current = ""
+	}
+	return nil
+}
+
+func (c* Config) migrate() error {
+	if c.Version > ConfigVersion {
+		return fmt.Errorf("config version %d is newer than supported %d", c.Version, ConfigVersion)
+	}
+	// version 0 is either the single hub config or unversioned profiles
+	if c.Version < 1 && c.SetName != "" && len(c.Hubs) == 0 {
+		c.Hubs = map[string]*HubProfile{
+			DefaultHubName: { UserId: c.UserId, SetName: c.SetName },
+		}
+		c.Current = DefaultHubName
+		c.UserId = 0
+		c.SetName = ""
+	}
+	c.Version = ConfigVersion
+	return nil
+}
+
+func (c Config) isFileExists(path string) (bool, error) {
+	_, err := os.Stat(path)
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return false, fmt.Errorf("check if file exists: %s", err)
 	}
 	return !errors.Is(err, os.ErrNotExist), nil
 }
 
+// writes to a temporary file and renames it over the config,
+// so an interrupted write never leaves a truncated config behind
 func (c Config) WriteFile() error {
-	file, err := os.OpenFile(ConfigPath, os.O_CREATE|os.O_WRONLY, 0644)
+	path := c.Path()
+	err := os.MkdirAll(filepath.Dir(path), 0700)
 	if err != nil {
-		return fmt.Errorf("open file: %s", err)
+		return fmt.Errorf("create config dir: %s", err)
 	}
-	defer file.Close()
-	err = json.NewEncoder(file).Encode(c)
-	return err
+	file, err := os.CreateTemp(filepath.Dir(path), ConfigFilename + ".*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %s", err)
+	}
+	defer os.Remove(file.Name())
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "\t")
+	err = enc.Encode(c)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write temp file: %s", err)
+	}
+	err = os.Chmod(file.Name(), 0600)
+	if err != nil {
+		return fmt.Errorf("chmod temp file: %s", err)
+	}
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		return fmt.Errorf("rename temp file: %s", err)
+	}
+	return nil
 }
 
 func (c* Config) FromFile() error {
-	file, err := os.OpenFile(ConfigPath, os.O_RDONLY, 0644)
+	err := c.readFile(c.Path())
+	if err != nil {
+		return err
+	}
+	if c.Version < ConfigVersion {
+		return c.WriteFile()
+	}
+	return nil
+}
+
+func (c* Config) readFile(path string) error {
+	file, err := os.OpenFile(path, os.O_RDONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("open file: %s", err)
 	}
@@ -55,5 +313,5 @@ func (c* Config) FromFile() error {
 	if err != nil {
 		return fmt.Errorf("json decode Config: %s", err)
 	}
-	return nil
+	return c.migrate()
 }
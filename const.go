@@ -5,12 +5,55 @@ import (
 )
 
 const (
- 	ConfigPath string = "config.json"
+ 	ConfigFilename string = "config.json"
+	LegacyConfigPath string = "config.json"
+	ConfigVersion int = 1
 	HubSignature string = "stickhub"
 	HubSignatureLength = 8
 	DefaultEmoji string = "🥰"
+	// first emoji of every chunk after the first one, marking where files start
+	ContinuationEmoji string = "🔗"
+	HubKeyword string = "tgsh"
+	// Telegram keeps up to 20 keywords of at most 64 characters in total
+	StickerKeywordsLimit int = 20
+	StickerKeywordsLength int = 64
+	StickerSetLimit int = 120
+	StickerSide int = 512
+	EmojiSetLimit int = 200
+	EmojiSide int = 100
+	StickerTypeRegular string = "regular"
+	StickerTypeCustomEmoji string = "custom_emoji"
+	FrameSignature string = "tgsf"
+	FrameHeaderLength int = 8
+	DefaultHubName string = "default"
+	DefaultTokenEnv string = "TOKEN"
+	UploadFormatWebp string = "webp"
+	UploadFormatPng string = "png"
+	CarrierAlpha string = "alpha"
+	CarrierTgs string = "tgs"
+	CarrierCover string = "cover"
+	// low bits of every color channel of a cover pixel that hold payload
+	CoverBits int = 2
+	// space around sticker labels, in pixels
+	LabelPadding int = 4
+	StickerFormatStatic string = "static"
+	StickerFormatAnimated string = "animated"
+	// Telegram limits animated stickers to 64 KB gzipped, 60 fps and 3 seconds
+	TgsMaxSize int = 64 * 1024
+	TgsCapacity int = 60 * 1024
+	TgsMaxDocumentSize int = 1 << 20
+	TgsFrameRate int = 60
+	TgsFrameCount int = 60
+	TgsPayloadPrefix string = "tgsh:"
+	// bytes of sha256 addressing a chunk
+	ChunkHashLength int = 16
+	// data stickers every group of parity stickers protects, unless configured
+	DefaultParityGroup int = 8
+	LottieLayerNull int = 3
+	LottieLayerShape int = 4
 )
 
 var (
 	ErrNotStickerHub = errors.New("not a sticker hub")
+	ErrDataLoss = errors.New("data loss")
 )
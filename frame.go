@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// every carrier payload starts with FrameSignature and the payload length,
+// so decoding does not depend on image size or on a terminating byte
+func frameData(data []byte) []byte {
+	framed := make([]byte, 0, FrameHeaderLength + len(data))
+	framed = append(framed, FrameSignature...)
+	framed = binary.BigEndian.AppendUint32(framed, uint32(len(data)))
+	return append(framed, data...)
+}
+
+func unframeData(data []byte) []byte {
+	if len(data) >= FrameHeaderLength && bytes.HasPrefix(data, []byte(FrameSignature)) {
+		length := int(binary.BigEndian.Uint32(data[len(FrameSignature):]))
+		if length <= len(data) - FrameHeaderLength {
+			return data[FrameHeaderLength:FrameHeaderLength + length]
+		}
+	}
+	// stickers uploaded before framing end at the first fully transparent pixel
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return data[:i]
+	}
+	return data
+}
@@ -3,37 +3,333 @@ package main
 import (
 	"fmt"
 	"os"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
 	"encoding/json"
-	"github.com/sergeykochiev/tgsh/png"
-	"github.com/sergeykochiev/tgsh/webp"
+	"github.com/sergeykochiev/tgsh/reedsolomon"
+	"github.com/sergeykochiev/tgsh/telegram"
 )
 
 type StickerHubInfoEntry struct {
 	Filename string `json:"Filename"`
+	Size int `json:"Size,omitempty"`
+	// file_unique_id of every sticker holding a chunk of the file, in order
+	Stickers []string `json:"Stickers,omitempty"`
+	// id of the Carrier the chunks are stored with, empty for the alpha one
+	Carrier string `json:"Carrier,omitempty"`
+	// tagged onto every chunk sticker as a keyword
+	Id string `json:"Id,omitempty"`
+	// crc32 of the payload of every sticker in Stickers
+	Checksums []string `json:"Checksums,omitempty"`
+	// data stickers per group and parity stickers after each, zero without parity
+	Group int `json:"Group,omitempty"`
+	Parity int `json:"Parity,omitempty"`
+	// bytes of the file in every data sticker but the last, or the most
+	// in one of them for content-chunked files
+	ChunkSize int `json:"ChunkSize,omitempty"`
+	// hash of every data chunk of a content-chunked file, whose stickers
+	// may be shared with other files holding the same chunk
+	Hashes []string `json:"Hashes,omitempty"`
+}
+
+// a chunk held by one or more content-chunked files
+type StickerHubChunk struct {
+	Sticker string `json:"Sticker"`
+	// how many times files hold the chunk, the sticker is deleted at zero
+	Refs int `json:"Refs"`
+	// bytes of the chunk, which parity groups pad to their longest one
+	Size int `json:"Size,omitempty"`
+	// type of the sets the sticker was made for, which sets its size
+	Pool string `json:"Pool,omitempty"`
 }
 
 type StickerHubInfo []StickerHubInfoEntry
 
+type StickerHubHeader struct {
+	Sets []string `json:"Sets,omitempty"`
+	Files StickerHubInfo `json:"Files"`
+	// by carrier and hash, missing in headers written before it was kept
+	Chunks map[string]StickerHubChunk `json:"Chunks,omitempty"`
+}
+
 type StickerHub struct {
+	bot *telegram.Bot
 	botUsername string
 	fileCount int
 	userId int
+	emoji string
+	carrier Carrier
+	pool string
+	parityGroup int
+	parity int
+	replicas []*StickerHub
+	// name of the hub this replica stands in for
+	standInFor string
 	info StickerHubInfo
-	telegramSet TelegramSet
+	chunks map[string]StickerHubChunk
+	sets []telegram.StickerSet
+	stickers []telegram.Sticker
 }
 
 func (sh StickerHub) GetInfoEntry(idx int) StickerHubInfoEntry {
  return sh.info[idx]
 }
 
+func (sh StickerHub) Files() StickerHubInfo {
+	return sh.info
+}
+
+func (sh StickerHub) Title() string {
+	return sh.sets[0].Title
+}
+
+func (sh StickerHub) Name() string {
+	return sh.sets[0].Name
+}
+
+func (sh StickerHub) SetNames() []string {
+	names := make([]string, 0, len(sh.sets))
+	for _, s := range(sh.sets) {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func (sh StickerHub) stickerByUniqueId(uniqueId string) (telegram.Sticker, bool) {
+	for _, s := range(sh.stickers) {
+		if s.UniqueId == uniqueId {
+			return s, true
+		}
+	}
+	return telegram.Sticker{}, false
+}
+
+func (sh StickerHub) FileStickers(idx int) []telegram.Sticker {
+	var out []telegram.Sticker
+	for _, id := range(sh.info[idx].Stickers) {
+		if s, ok := sh.stickerByUniqueId(id); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (sh* StickerHub) WithBot(bot *telegram.Bot) {
+	sh.bot = bot
+}
+
+func (sh* StickerHub) WithEmoji(emoji string) {
+	sh.emoji = emoji
+}
+
+func (sh StickerHub) stickerEmoji() string {
+	if sh.emoji == "" {
+		return DefaultEmoji
+	}
+	return sh.emoji
+}
+
+// sets the carrier new files are uploaded with
+func (sh* StickerHub) WithCarrier(id string, format string) error {
+	c, err := newCarrier(id, format)
+	if err != nil {
+		return err
+	}
+	sh.carrier = c
+	return nil
+}
+
+// hides new files in the image at path instead of blank stickers
+func (sh* StickerHub) WithCover(path string, format string) error {
+	cover, err := loadCover(path)
+	if err != nil {
+		return err
+	}
+	sh.carrier = coverCarrier{ cover: cover, png: format == UploadFormatPng }
+	return nil
+}
+
+// sets the type of sets new files go to
+func (sh* StickerHub) WithPool(pool string) error {
+	switch pool {
+	case "", StickerTypeRegular, StickerTypeCustomEmoji:
+		sh.pool = pool
+		return nil
+	default:
+		return fmt.Errorf("unknown pool \"%s\", use %s or %s", pool, StickerTypeRegular, StickerTypeCustomEmoji)
+	}
+}
+
+// sets how many parity stickers protect every group of data stickers of new files
+func (sh* StickerHub) WithParity(group int, parity int) error {
+	if group == 0 {
+		group = DefaultParityGroup
+	}
+	if group < 1 || parity < 0 || group + parity > 256 {
+		return fmt.Errorf("invalid parity of %d stickers per %d: groups have to fit 256 stickers", parity, group)
+	}
+	sh.parityGroup = group
+	sh.parity = parity
+	return nil
+}
+
+func (sh StickerHub) uploadPool() string {
+	if sh.pool == "" {
+		return StickerTypeRegular
+	}
+	return sh.pool
+}
+
+func setType(set telegram.StickerSet) string {
+	if set.StickerType == "" {
+		return StickerTypeRegular
+	}
+	return set.StickerType
+}
+
+func setLimit(stickerType string) int {
+	if stickerType == StickerTypeCustomEmoji {
+		return EmojiSetLimit
+	}
+	return StickerSetLimit
+}
+
+func stickerSize(stickerType string) (int, int) {
+	if stickerType == StickerTypeCustomEmoji {
+		return EmojiSide, EmojiSide
+	}
+	return StickerSide, StickerSide
+}
+
+func (sh StickerHub) uploadCarrier() Carrier {
+	if sh.carrier == nil {
+		return alphaCarrier{}
+	}
+	return sh.carrier
+}
+
+// the header has to be readable before any entry is, so it always uses the alpha carrier
+func (sh StickerHub) headerCarrier() Carrier {
+	if c, ok := sh.carrier.(alphaCarrier); ok {
+		return c
+	}
+	return alphaCarrier{}
+}
+
+// a file holding a sticker as the index-th of its count stickers
+type stickerOwner struct {
+	id string
+	index int
+	count int
+}
+
+// files holding the sticker, by the position they hold it at
+func (sh StickerHub) stickerOwners(uniqueId string) []stickerOwner {
+	var owners []stickerOwner
+	for _, e := range(sh.info) {
+		for i, id := range(e.Stickers) {
+			if id == uniqueId && e.Id != "" {
+				owners = append(owners, stickerOwner{ e.Id, i, len(e.Stickers) })
+			}
+		}
+	}
+	return owners
+}
+
+// emoji and keywords of a sticker held by owners: the file id and position of
+// every owner, as many as Telegram keeps, which list --sets groups stickers by.
+// reading files only goes by the header
+func (sh StickerHub) ownerTags(owners []stickerOwner) ([]string, []string) {
+	emoji := ContinuationEmoji
+	keywords := []string{ HubKeyword }
+	length := len(HubKeyword)
+	for _, o := range(owners) {
+		if o.index == 0 {
+			emoji = sh.stickerEmoji()
+		}
+		position := fmt.Sprintf("%d/%d", o.index + 1, o.count)
+		if len(keywords) + 2 > StickerKeywordsLimit || length + len(o.id) + len(position) > StickerKeywordsLength {
+			continue
+		}
+		keywords = append(keywords, o.id, position)
+		length += len(o.id) + len(position)
+	}
+	return []string{ emoji }, keywords
+}
+
+// emoji and keywords of the i-th of count chunk stickers of a file, naming
+// the other files holding the same chunk as well
+func (sh StickerHub) chunkTags(e StickerHubInfoEntry, i int, count int) ([]string, []string) {
+	owners := []stickerOwner{ { e.Id, i, count } }
+	if i < len(e.Stickers) {
+		for _, o := range(sh.stickerOwners(e.Stickers[i])) {
+			if o.id != e.Id || o.index != i {
+				owners = append(owners, o)
+			}
+		}
+	}
+	return sh.ownerTags(owners)
+}
+
+// retags stickers whose owners changed, which only makes searching by
+// keyword go wrong if it fails, so failures are reported and skipped
+func (sh* StickerHub) retagStickers(uniqueIds []string) {
+	done := map[string]bool{}
+	for _, id := range(uniqueIds) {
+		s, ok := sh.stickerByUniqueId(id)
+		if done[id] || !ok {
+			continue
+		}
+		done[id] = true
+		owners := sh.stickerOwners(id)
+		if len(owners) == 0 {
+			continue
+		}
+		emoji, keywords := sh.ownerTags(owners)
+		ok, err := sh.bot.SetStickerEmojiList(s.FileId, emoji)
+		if err == nil && ok {
+			ok, err = sh.bot.SetStickerKeywords(s.FileId, keywords)
+		}
+		if err == nil && !ok {
+			err = errors.New("returned false")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not retag sticker %s: %s\n", id, err)
+		}
+	}
+}
+
 func (sh* StickerHub) OfUser(userId int) error {
-	// TODO add logic that check for userId validity
 	sh.userId = userId
 	return nil
 }
 
+// bots can only see users who have started a conversation with them,
+// which is also what sticker set methods require
+func (sh StickerHub) CheckUser() error {
+	chat, err := sh.bot.GetChat(sh.userId)
+	var tgErr telegram.Error
+	if errors.As(err, &tgErr) && tgErr.Status == 400 {
+		return fmt.Errorf("user %d is unknown to @%s: check the id and make sure the user has sent /start to https://t.me/%s", sh.userId, sh.botUsername, sh.botUsername)
+	}
+	if err != nil {
+		return fmt.Errorf("get chat: %s", err)
+	}
+	if chat.Type != "private" {
+		return fmt.Errorf("%d is a %s, not a user: sticker sets can only be owned by users", sh.userId, chat.Type)
+	}
+	name := chat.FirstName
+	if chat.Username != "" {
+		name = "@" + chat.Username
+	}
+	fmt.Printf("Using user %s (%d)\n", name, sh.userId)
+	return nil
+}
+
 func (sh* StickerHub) GetUsername() error {
-	user, err := getMe()
+	user, err := sh.bot.GetMe()
 	if err != nil {
 		return err
 	}
@@ -45,30 +341,31 @@ func (sh StickerHub) UserId() int {
 	return sh.userId
 }
 
-func (sh StickerHub) GetInfoSticker() TelegramSticker {
-	return sh.telegramSet.Stickers[0]
+func (sh StickerHub) GetInfoSticker() telegram.Sticker {
+	return sh.stickers[0]
 }
 
 func (sh* StickerHub) FromNewSet(title string) error {
-	headerData, err := sh.createEmptyInfoFile()
+	headerData, format, err := sh.createInfoFile(StickerHubHeader{ Files: StickerHubInfo{} })
 	if err != nil {
 		return fmt.Errorf("create empty info file: %s", err)
 	}
-	file, err := uploadStickerFile(sh.userId, "header", headerData)
+	file, err := sh.bot.UploadStickerFile(sh.userId, format, "header", headerData)
 	if err != nil {
 		return fmt.Errorf("upload sticker file: %s", err)
 	}
 	name := generateNewSetName(sh.botUsername)
 	fmt.Printf("Creating set with name \"%s\"\n", name)
-	ok, err := createNewStickerSet(TelegramParamsCreateNewStickerSet{
+	ok, err := sh.bot.CreateNewStickerSet(telegram.ParamsCreateNewStickerSet{
  		UserId: sh.userId,
  		Name: name,
  		Title: title,
- 		Stickers: []TelegramInputSticker{
+ 		Stickers: []telegram.InputSticker{
 			{
-				FileId: file.Id,
-				Format: "static",
-				EmojiList: []string{ DefaultEmoji },
+				Sticker: file.Id,
+				Format: format,
+				EmojiList: []string{ sh.stickerEmoji() },
+				Keywords: []string{ HubKeyword, "header" },
 			},
 		},
  	})
@@ -82,46 +379,35 @@ func (sh* StickerHub) FromNewSet(title string) error {
 	return sh.FromExistingSet(name)
 }
 
-func (sh StickerHub) encodeDataToPng(data []byte) ([]byte, error) {
-	var p png.PngImage
-	p.Default(512, 512, data)
-	output := p.Encode()
-	return output, nil
-}
-
-func (sh StickerHub) createInfoFile(info StickerHubInfo) ([]byte, error) {
-	bytes, err := json.Marshal(info)
+func (sh StickerHub) createInfoFile(header StickerHubHeader) ([]byte, string, error) {
+	bytes, err := json.Marshal(header)
 	if err != nil {
-		return nil, fmt.Errorf("json encode Info: %s", err)
+		return nil, "", fmt.Errorf("json encode Info: %s", err)
 	}
-	return sh.encodeDataToPng(append([]byte(HubSignature), bytes...))
-}
-
-func (sh StickerHub) createEmptyInfoFile() ([]byte, error) {
-	return sh.createInfoFile(StickerHubInfo{})
+	return sh.headerCarrier().Encode(append([]byte(HubSignature), bytes...), CarrierTarget{
+		Width: StickerSide,
+		Height: StickerSide,
+		Label: []string{ HubSignature, fmt.Sprintf("%d files", len(header.Files)) },
+	})
 }
 
 func (sh* StickerHub) ListFiles() {
-	if sh.fileCount <= 1 {
-		fmt.Printf("Stickerhub \"%s\" is empty\n", sh.telegramSet.Title)
+	if len(sh.info) == 0 {
+		fmt.Printf("Stickerhub \"%s\" is empty\n", sh.Title())
 		return
 	}
-	fmt.Printf("Files in stickerhub \"%s\" (%d total):\n", sh.telegramSet.Title, sh.fileCount - 1)
+	fmt.Printf("Files in stickerhub \"%s\" (%d total):\n", sh.Title(), len(sh.info))
 	for _, e := range(sh.info) {
 		fmt.Println(e.Filename)
 	}
 }
 
-func (sh* StickerHub) decodeFileData(fileData []byte) ([]byte, error) {
-	return webp.Decode(fileData)
-}
-
-func (sh* StickerHub) GetFile(fileId string) ([]byte, error) {
-	bytes, err := getFileData(fileId)
+func (sh* StickerHub) GetFile(fileId string, c Carrier) ([]byte, error) {
+	bytes, err := sh.bot.GetFileData(fileId)
 	if err != nil {
 		return nil, fmt.Errorf("get file data: %s", err)
 	}
-	decoded, err := sh.decodeFileData(bytes)
+	decoded, err := c.Decode(bytes)
 	if err != nil {
 		return nil, fmt.Errorf("decode file data: %s", err)
 	}
@@ -133,11 +419,11 @@ func (sh* StickerHub) getHeaderData() ([]byte, error) {
 	if sh.fileCount == 0 {
 		return nil, fmt.Errorf("file count is 0")
 	}
-	fileData, err := getFileData(sh.GetInfoSticker().FileId)
+	fileData, err := sh.bot.GetFileData(sh.GetInfoSticker().FileId)
 	if err != nil {
 		return nil, fmt.Errorf("get file data: %s", err)
 	}
-	decoded, err := sh.decodeFileData(fileData)
+	decoded, err := sh.headerCarrier().Decode(fileData)
 	if err != nil {
 		return nil, fmt.Errorf("decode file data: %s", err)
 	}
@@ -145,90 +431,685 @@ func (sh* StickerHub) getHeaderData() ([]byte, error) {
 	return concatData, nil
 }
 
-func (sh* StickerHub) UploadFile(filename string) error {
-	newSticker := TelegramInputSticker{
-		Format: "static",
-		EmojiList: []string{ DefaultEmoji },
+// accepts a filename or a 1-based index, returns index into the info
+func (sh* StickerHub) FindFile(arg string) (int, error) {
+	index, err := strconv.Atoi(arg)
+	if err != nil {
+		for i, e := range(sh.info) {
+			if e.Filename == arg {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("no file named \"%s\"", arg)
+	}
+	if index > len(sh.info) || index <= 0 {
+		return 0, errors.New("Invalid index")
+	}
+	return index - 1, nil
+}
+
+// payload of the i-th sticker of a file, checked against its recorded checksum
+func (sh* StickerHub) readChunk(e StickerHubInfoEntry, i int, c Carrier) ([]byte, error) {
+	s, ok := sh.stickerByUniqueId(e.Stickers[i])
+	if !ok {
+		return nil, errors.New("missing from the hub")
+	}
+	data, err := sh.GetFile(s.FileId, c)
+	if err != nil {
+		return nil, err
+	}
+	if i < len(e.Checksums) && chunkChecksum(data) != e.Checksums[i] {
+		return nil, errors.New("checksum mismatch")
 	}
-	fileData, err := os.ReadFile(filename)
+	return data, nil
+}
+
+func (sh* StickerHub) readFile(idx int) ([]byte, error) {
+	var output []byte
+	e := sh.info[idx]
+	c, err := newCarrier(e.Carrier, "")
+	if err != nil {
+		return nil, fmt.Errorf("\"%s\": %s", e.Filename, err)
+	}
+	if e.Parity > 0 {
+		return sh.readWithParity(e, c)
+	}
+	for i := range(e.Stickers) {
+		data, err := sh.readChunk(e, i, c)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d of \"%s\": %s", i + 1, e.Filename, err)
+		}
+		output = append(output, data...)
+	}
+	return output, nil
+}
+
+// rebuilds data stickers that are missing or damaged from the parity ones,
+// as long as no group has lost more stickers than it has parity stickers
+func (sh* StickerHub) readWithParity(e StickerHubInfoEntry, c Carrier) ([]byte, error) {
+	code, err := reedsolomon.New(e.Group, e.Parity)
+	if err != nil {
+		return nil, fmt.Errorf("\"%s\": %s", e.Filename, err)
+	}
+	count := e.chunkCount()
+	if len(e.Stickers) != e.stickerCount() {
+		return nil, fmt.Errorf("\"%s\": %d stickers do not match the parity layout", e.Filename, len(e.Stickers))
+	}
+	var output []byte
+	next := 0
+	for start := 0; start < count; start += e.Group {
+		dataCount := min(e.Group, count - start)
+		shards := make([][]byte, e.Group + e.Parity)
+		// stickers read back are the size of their chunk, recovered ones are padded
+		sizes := make([]int, dataCount)
+		for j := range(sizes) {
+			sizes[j] = -1
+		}
+		lost := 0
+		for j := range(dataCount + e.Parity) {
+			slot := j
+			if j >= dataCount {
+				slot = e.Group + j - dataCount
+			}
+			data, err := sh.readChunk(e, next + j, c)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Sticker %d of \"%s\": %s\n", next + j + 1, e.Filename, err)
+				if j < dataCount {
+					lost++
+				}
+				continue
+			}
+			shards[slot] = data
+			if j < dataCount {
+				sizes[j] = len(data)
+			}
+		}
+		next += dataCount + e.Parity
+		if lost > 0 {
+			if err = recoverGroup(code, shards, dataCount); err != nil {
+				return nil, fmt.Errorf("\"%s\": chunks %d-%d cannot be recovered: %s", e.Filename, start + 1, start + dataCount, err)
+			}
+			fmt.Fprintf(os.Stderr, "Recovered %d chunks of \"%s\" from parity\n", lost, e.Filename)
+		}
+		for j := range(dataCount) {
+			size := sizes[j]
+			if size < 0 {
+				size = sh.chunkLength(e, start + j)
+			}
+			if size < 0 || size > len(shards[j]) {
+				return nil, fmt.Errorf("\"%s\": size of chunk %d is not recorded", e.Filename, start + j + 1)
+			}
+			output = append(output, shards[j][:size]...)
+		}
+	}
+	return output, nil
+}
+
+// bytes in the j-th data chunk of a file, or -1 if the header does not say
+func (sh StickerHub) chunkLength(e StickerHubInfoEntry, j int) int {
+	if len(e.Hashes) == 0 {
+		return min(e.ChunkSize, e.Size - j * e.ChunkSize)
+	}
+	if record, ok := sh.chunks[chunkKey(e.Carrier, e.Hashes[j])]; ok {
+		return record.Size
+	}
+	return -1
+}
+
+func (sh* StickerHub) UploadFile(path string, filename string) error {
+	fileData, err := os.ReadFile(path)
 	if err != nil {
 		return fmt.Errorf("read file: %s", err)
 	}
-	encoded, err := sh.encodeDataToPng(fileData)
+	return sh.UploadData(filename, fileData)
+}
+
+// writes the file to the hub under id, generating one if it is empty. chunks
+// already in the hub are reused unless their sticker is one of stale
+func (sh* StickerHub) uploadData(filename string, fileData []byte, id string, stale map[string]bool) error {
+	if id == "" {
+		id = generateFileId()
+	}
+	c := sh.uploadCarrier()
+	pool := sh.uploadPool()
+	w, h := stickerSize(pool)
+	entry := StickerHubInfoEntry{ Filename: filename, Size: len(fileData), Carrier: c.Id(), Id: id }
+	capacity := c.Capacity(w, h)
+	if capacity <= 0 {
+		return fmt.Errorf("carrier \"%s\" has no room for data in a %dx%d sticker", c.Id(), w, h)
+	}
+	entry.ChunkSize = capacity
+	chunks := contentChunks(fileData, capacity)
+	sizes := make([]int, len(chunks))
+	for j, chunk := range(chunks) {
+		entry.Hashes = append(entry.Hashes, chunkHash(chunk))
+		sizes[j] = len(chunk)
+	}
+	// parity is computed over the chunks as they are, so they are reused all the same
+	if sh.parity > 0 {
+		entry.Group = sh.parityGroup
+		entry.Parity = sh.parity
+	}
+	payloads, err := addParity(chunks, entry.Group, entry.Parity)
+	if err != nil {
+		return fmt.Errorf("compute parity: %s", err)
+	}
+	labels := entry.stickerLabels()
+	reused := 0
+	var added []string
+	// stickers held at more positions than they were tagged with
+	var shared []string
+	// chunks repeated within the file
+	uploaded := map[string]string{}
+	setCount := len(sh.sets)
+	for i, payload := range(payloads) {
+		entry.Checksums = append(entry.Checksums, chunkChecksum(payload))
+		if j := entry.dataIndex(i); j >= 0 {
+			if uniqueId, ok := uploaded[entry.Hashes[j]]; ok {
+				entry.Stickers = append(entry.Stickers, uniqueId)
+				shared = append(shared, uniqueId)
+				continue
+			}
+			if uniqueId, ok := sh.chunkSticker(c.Id(), entry.Hashes[j]); ok && !stale[uniqueId] {
+				entry.Stickers = append(entry.Stickers, uniqueId)
+				shared = append(shared, uniqueId)
+				reused++
+				continue
+			}
+		}
+		input, err := sh.uploadSticker(entry, i, payload, c, w, h)
+		if err != nil {
+			return sh.discardStickers(added, setCount, err)
+		}
+		if len(payloads) > 1 {
+			fmt.Printf("Uploaded %s\n", labels[i])
+		}
+		sticker, err := sh.addSticker(input, pool)
+		if err != nil {
+			return sh.discardStickers(added, setCount, err)
+		}
+		added = append(added, sticker.UniqueId)
+		entry.Stickers = append(entry.Stickers, sticker.UniqueId)
+		if j := entry.dataIndex(i); j >= 0 {
+			uploaded[entry.Hashes[j]] = sticker.UniqueId
+		}
+	}
+	if reused > 0 {
+		fmt.Printf("Reused %d of %d chunks already in the hub\n", reused, len(chunks))
+	}
+	oldInfo, oldChunks := sh.info.clone(), maps.Clone(sh.chunks)
+	sh.addChunkRefs(entry, sizes, pool)
+	sh.info = append(sh.info, entry)
+	// stickers of a file the header does not list would be left in the sets for good
+	if err := sh.storeHeader(); err != nil {
+		sh.info, sh.chunks = oldInfo, oldChunks
+		return sh.discardStickers(added, setCount, err)
+	}
+	if err := sh.RefetchSet(); err != nil {
+		return err
+	}
+	sh.retagStickers(shared)
+	return nil
+}
+
+// copy of the entries whose sticker lists can be changed without touching these
+func (info StickerHubInfo) clone() StickerHubInfo {
+	out := make(StickerHubInfo, len(info))
+	for i, e := range(info) {
+		e.Stickers = slices.Clone(e.Stickers)
+		out[i] = e
+	}
+	return out
+}
+
+// undoes a failed upload, which neither the header nor the chunk counts recorded:
+// deletes the stickers it added and the sets past the first setCount it started,
+// then returns cause
+func (sh* StickerHub) discardStickers(uniqueIds []string, setCount int, cause error) error {
+	started := map[string]bool{}
+	for _, set := range(sh.sets[setCount:]) {
+		started[set.Name] = true
+	}
+	for _, id := range(uniqueIds) {
+		k, _, ok := sh.stickerPosition(id)
+		if !ok || started[sh.sets[k].Name] {
+			continue
+		}
+		s, _ := sh.stickerByUniqueId(id)
+		if _, err := sh.bot.DeleteStickerFromSet(s.FileId); err != nil {
+			return fmt.Errorf("%s, and uploaded stickers could not be deleted: %s", cause, err)
+		}
+	}
+	for name := range(started) {
+		if _, err := sh.bot.DeleteStickerSet(name); err != nil {
+			return fmt.Errorf("%s, and set \"%s\" could not be deleted: %s", cause, name, err)
+		}
+	}
+	sh.sets = sh.sets[:setCount]
+	discarded := map[string]bool{}
+	for _, id := range(uniqueIds) {
+		discarded[id] = true
+	}
+	kept := sh.stickers[:0]
+	for _, s := range(sh.stickers) {
+		if !discarded[s.UniqueId] {
+			kept = append(kept, s)
+		}
+	}
+	sh.stickers = kept
+	for k := range(sh.sets) {
+		var stickers []telegram.Sticker
+		for _, s := range(sh.sets[k].Stickers) {
+			if !discarded[s.UniqueId] {
+				stickers = append(stickers, s)
+			}
+		}
+		sh.sets[k].Stickers = stickers
+	}
+	return cause
+}
+
+// encodes the payload of the i-th sticker of a file into a w by h sticker
+// and uploads it, returning it tagged and ready to be added to a set
+func (sh* StickerHub) uploadSticker(e StickerHubInfoEntry, i int, payload []byte, c Carrier, w int, h int) (telegram.InputSticker, error) {
+	labels := e.stickerLabels()
+	// files reusing the chunk later are only named in its keywords
+	encoded, format, err := c.Encode(payload, CarrierTarget{
+		Width: w,
+		Height: h,
+		Label: []string{ e.Filename, labels[i], formatSize(e.Size) },
+	})
 	if err != nil {
-		return fmt.Errorf("encode data to png: %s", err)
+		return telegram.InputSticker{}, fmt.Errorf("encode data: %s", err)
 	}
-	file, err := uploadStickerFile(sh.userId, filename, encoded)
+	file, err := sh.bot.UploadStickerFile(sh.userId, format, e.Filename, encoded)
 	if err != nil {
-		return fmt.Errorf("upload sticker file: %s", err)
+		return telegram.InputSticker{}, fmt.Errorf("upload sticker file: %s", err)
+	}
+	emoji, keywords := sh.chunkTags(e, i, len(labels))
+	return telegram.InputSticker{
+		Sticker: file.Id,
+		Format: format,
+		EmojiList: emoji,
+		Keywords: keywords,
+	}, nil
+}
+
+// adds sticker to the last set of its type in the chain and returns it as stored by Telegram
+func (sh* StickerHub) addSticker(sticker telegram.InputSticker, stickerType string) (telegram.Sticker, error) {
+	tail := -1
+	for i, set := range(sh.sets) {
+		if setType(set) == stickerType {
+			tail = i
+		}
 	}
-	newSticker.FileId = file.Id
-	ok, err := addStickerToSet(TelegramParamsAddStickerToSet{
+	if tail < 0 || len(sh.sets[tail].Stickers) >= setLimit(stickerType) {
+		return sh.extendChain(sticker, stickerType)
+	}
+	ok, err := sh.bot.AddStickerToSet(telegram.ParamsAddStickerToSet{
 		UserId: sh.userId,
-		Name: sh.telegramSet.Name,
-		Sticker: newSticker,
+		Name: sh.sets[tail].Name,
+		Sticker: sticker,
 	})
 	if err != nil {
-		return fmt.Errorf("add sticker to set: %s", err)
+		return telegram.Sticker{}, fmt.Errorf("add sticker to set: %s", err)
 	}
 	if !ok {
-		return fmt.Errorf("add sticker to set: returned false")
+		return telegram.Sticker{}, fmt.Errorf("add sticker to set: returned false")
+	}
+	return sh.refetchChainSet(tail)
+}
+
+// returns the sticker added last to the i-th set of the chain
+func (sh* StickerHub) refetchChainSet(i int) (telegram.Sticker, error) {
+	old := &sh.sets[i]
+	set, err := sh.bot.GetStickerSet(old.Name)
+	if err != nil {
+		return telegram.Sticker{}, fmt.Errorf("get sticker set: %s", err)
+	}
+	if len(set.Stickers) == 0 {
+		return telegram.Sticker{}, fmt.Errorf("set \"%s\" is empty after adding a sticker", old.Name)
+	}
+	sh.stickers = append(sh.stickers, set.Stickers[len(set.Stickers) - 1])
+	*old = set
+	return set.Stickers[len(set.Stickers) - 1], nil
+}
+
+// continues the hub in a new set once the last one of the type is full
+func (sh* StickerHub) extendChain(first telegram.InputSticker, stickerType string) (telegram.Sticker, error) {
+	name := generateNewSetName(sh.botUsername)
+	title := fmt.Sprintf("%s (%d)", sh.Title(), len(sh.sets) + 1)
+	if stickerType == StickerTypeCustomEmoji {
+		fmt.Printf("Continuing in custom emoji set \"%s\"\n", name)
+	} else {
+		fmt.Printf("Set is full, continuing in set \"%s\"\n", name)
 	}
-	err = sh.RefetchSet()
+	ok, err := sh.bot.CreateNewStickerSet(telegram.ParamsCreateNewStickerSet{
+		UserId: sh.userId,
+		Name: name,
+		Title: title,
+		StickerType: stickerType,
+		Stickers: []telegram.InputSticker{ first },
+	})
 	if err != nil {
-		return fmt.Errorf("refetch set: %s", err)
+		return telegram.Sticker{}, fmt.Errorf("create new sticker set: %s", err)
+	}
+	if !ok {
+		return telegram.Sticker{}, fmt.Errorf("create new sticker set: returned false")
+	}
+	sh.sets = append(sh.sets, telegram.StickerSet{ Name: name, Title: title, StickerType: stickerType })
+	return sh.refetchChainSet(len(sh.sets) - 1)
+}
+
+func (sh* StickerHub) writeHeader() error {
+	if err := sh.storeHeader(); err != nil {
+		return err
 	}
-	sh.info = append(sh.info, StickerHubInfoEntry{ Filename: filename })
-	encoded, err = sh.createInfoFile(sh.info)
+	return sh.RefetchSet()
+}
+
+// replaces the header sticker, leaving the sets as they were fetched
+func (sh* StickerHub) storeHeader() error {
+	encoded, format, err := sh.createInfoFile(StickerHubHeader{
+		Sets: sh.SetNames(),
+		Files: sh.info,
+		Chunks: sh.chunks,
+	})
 	if err != nil {
 		return fmt.Errorf("create info file: %s", err)
 	}
-	file, err = uploadStickerFile(sh.userId, "header", encoded)
+	file, err := sh.bot.UploadStickerFile(sh.userId, format, "header", encoded)
 	if err != nil {
 		return fmt.Errorf("upload sticker file: %s", err)
 	}
-	newSticker.FileId = file.Id
-	ok, err = replaceStickerInSet(TelegramParamsReplaceStickerInSet{
+	ok, err := sh.bot.ReplaceStickerInSet(telegram.ParamsReplaceStickerInSet{
 		UserId: sh.userId,
-		Name: sh.telegramSet.Name,
-		OldFileId: sh.GetInfoSticker().FileId,
-		Sticker: newSticker,
+		Name: sh.Name(),
+		OldSticker: sh.GetInfoSticker().FileId,
+		Sticker: telegram.InputSticker{
+			Sticker: file.Id,
+			Format: format,
+			EmojiList: []string{ sh.stickerEmoji() },
+			Keywords: []string{ HubKeyword, "header" },
+		},
 	})
 	if err != nil {
-		return fmt.Errorf("add sticker to set: %s", err)
+		return fmt.Errorf("replace sticker in set: %s", err)
 	}
 	if !ok {
-		return fmt.Errorf("add sticker to set: returned false")
-	} 
+		return fmt.Errorf("replace sticker in set: returned false")
+	}
 	return nil
 }
 
-func (sh* StickerHub) parseHeader() error {
+// retitles every set in the chain, numbering all but the first one
+func (sh* StickerHub) Rename(title string) error {
+	if err := sh.checkWritable(); err != nil {
+		return err
+	}
+	for i := range(sh.sets) {
+		setTitle := title
+		if i > 0 {
+			setTitle = fmt.Sprintf("%s (%d)", title, i + 1)
+		}
+		ok, err := sh.bot.SetStickerSetTitle(sh.sets[i].Name, setTitle)
+		if err != nil {
+			return fmt.Errorf("set sticker set title: %s", err)
+		}
+		if !ok {
+			return fmt.Errorf("set sticker set title: returned false")
+		}
+		sh.sets[i].Title = setTitle
+	}
+	return nil
+}
+
+// deletes the stickers of a file that no other file holds chunks in
+func (sh* StickerHub) removeFile(idx int) error {
+	unused := sh.dropChunkRefs(idx)
+	// stickers other files still hold name the removed file in their keywords
+	var kept []string
+	for _, id := range(sh.info[idx].Stickers) {
+		if !slices.Contains(unused, id) {
+			kept = append(kept, id)
+		}
+	}
+	for _, id := range(unused) {
+		s, ok := sh.stickerByUniqueId(id)
+		if !ok {
+			continue
+		}
+		ok, err := sh.bot.DeleteStickerFromSet(s.FileId)
+		if err != nil {
+			return fmt.Errorf("delete sticker from set: %s", err)
+		}
+		if !ok {
+			return fmt.Errorf("delete sticker from set: returned false")
+		}
+	}
+	sh.info = append(sh.info[:idx], sh.info[idx + 1:]...)
+	if err := sh.writeHeader(); err != nil {
+		return err
+	}
+	sh.retagStickers(kept)
+	return nil
+}
+
+// tags stickers of files uploaded before tagging, giving their entries ids
+func (sh* StickerHub) Retag() error {
+	if err := sh.checkWritable(); err != nil {
+		return err
+	}
+	assigned := false
+	for idx := range(sh.info) {
+		e := &sh.info[idx]
+		if e.Id == "" {
+			e.Id = generateFileId()
+			assigned = true
+		}
+		tagged := 0
+		// positions are those in the entry, which counts missing stickers too
+		for i, id := range(e.Stickers) {
+			s, ok := sh.stickerByUniqueId(id)
+			if !ok {
+				fmt.Printf("Sticker %d of \"%s\" is missing, run scrub to repair it\n", i + 1, e.Filename)
+				continue
+			}
+			emoji, keywords := sh.chunkTags(*e, i, len(e.Stickers))
+			ok, err := sh.bot.SetStickerEmojiList(s.FileId, emoji)
+			if err == nil && ok {
+				ok, err = sh.bot.SetStickerKeywords(s.FileId, keywords)
+			}
+			if err != nil {
+				return fmt.Errorf("tag chunk %d of \"%s\": %s", i + 1, e.Filename, err)
+			}
+			if !ok {
+				return fmt.Errorf("tag chunk %d of \"%s\": returned false", i + 1, e.Filename)
+			}
+			tagged++
+		}
+		fmt.Printf("Tagged \"%s\" (%d stickers)\n", e.Filename, tagged)
+	}
+	if !assigned {
+		return nil
+	}
+	return sh.writeHeader()
+}
+
+// owners a sticker names in its keywords, nil if it was not tagged as a chunk
+func parseOwners(keywords []string) []stickerOwner {
+	if len(keywords) == 0 || keywords[0] != HubKeyword {
+		return nil
+	}
+	var owners []stickerOwner
+	for k := 1; k + 1 < len(keywords); k += 2 {
+		var o stickerOwner
+		if _, err := fmt.Sscanf(keywords[k + 1], "%d/%d", &o.index, &o.count); err != nil {
+			continue
+		}
+		if o.index < 1 || o.index > o.count {
+			continue
+		}
+		o.id = keywords[k]
+		o.index--
+		owners = append(owners, o)
+	}
+	return owners
+}
+
+// a file as told apart by the stickers of the chain
+type StickerGroup struct {
+	Id string
+	// empty for files the header has no entry for
+	Filename string
+	// by position in the file, missing ones are left zero
+	Stickers []telegram.Sticker
+	// positions filled from keywords, the rest came from the header
+	Tagged int
+}
+
+func (g StickerGroup) Found() int {
+	n := 0
+	for _, s := range(g.Stickers) {
+		if s.UniqueId != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// stickers of the chain grouped into files by the file ids and positions in
+// their keywords, without decoding anything. positions keywords leave open, such
+// as those of files tagged before tagging or of chunks with more owners than
+// keywords fit, are filled from the header. stickers of no file are returned apart
+func (sh StickerHub) StickerGroups() ([]StickerGroup, []telegram.Sticker) {
+	var groups []StickerGroup
+	byId := map[string]int{}
+	group := func(id string, filename string, count int) *StickerGroup {
+		k, ok := byId[id]
+		if !ok {
+			k = len(groups)
+			byId[id] = k
+			groups = append(groups, StickerGroup{ Id: id, Filename: filename })
+		}
+		g := &groups[k]
+		if filename != "" {
+			g.Filename = filename
+		}
+		for len(g.Stickers) < count {
+			g.Stickers = append(g.Stickers, telegram.Sticker{})
+		}
+		return g
+	}
+	// files of the header go first, in its order
+	for idx, e := range(sh.info) {
+		group(entryKey(idx, e), e.Filename, 0)
+	}
+	var stickers []telegram.Sticker
+	for i, set := range(sh.sets) {
+		if i == 0 && len(set.Stickers) > 0 {
+			// header
+			stickers = append(stickers, set.Stickers[1:]...)
+		} else {
+			stickers = append(stickers, set.Stickers...)
+		}
+	}
+	placed := map[string]bool{}
+	for _, s := range(stickers) {
+		for _, o := range(parseOwners(s.Keywords)) {
+			g := group(o.id, "", o.count)
+			if g.Stickers[o.index].UniqueId == "" {
+				g.Stickers[o.index] = s
+				g.Tagged++
+			}
+			placed[s.UniqueId] = true
+		}
+	}
+	for idx, e := range(sh.info) {
+		g := group(entryKey(idx, e), "", len(e.Stickers))
+		for i, id := range(e.Stickers) {
+			if g.Stickers[i].UniqueId != "" {
+				continue
+			}
+			if s, ok := sh.stickerByUniqueId(id); ok {
+				g.Stickers[i] = s
+				placed[id] = true
+			}
+		}
+	}
+	var orphans []telegram.Sticker
+	for _, s := range(stickers) {
+		if !placed[s.UniqueId] {
+			orphans = append(orphans, s)
+		}
+	}
+	return groups, orphans
+}
+
+// id a file is grouped by, entries put before ids by their position
+func entryKey(idx int, e StickerHubInfoEntry) string {
+	if e.Id == "" {
+		return fmt.Sprintf("#%d", idx + 1)
+	}
+	return e.Id
+}
+
+func (sh* StickerHub) ListStickerGroups() {
+	groups, orphans := sh.StickerGroups()
+	fmt.Printf("Files found in sets of \"%s\" (%d total):\n", sh.Title(), len(groups))
+	for _, g := range(groups) {
+		name := g.Filename
+		if name == "" {
+			name = "(not in header)"
+		}
+		line := fmt.Sprintf("%d of %d stickers, %d by keywords", g.Found(), len(g.Stickers), g.Tagged)
+		fmt.Println(name, g.Id, line)
+	}
+	if len(orphans) > 0 {
+		fmt.Printf("%d stickers in the sets belong to no file:\n", len(orphans))
+		for _, s := range(orphans) {
+			fmt.Println(s.UniqueId, s.Emoji, "in", s.SetName)
+		}
+	}
+}
+
+func (sh* StickerHub) parseHeader() ([]string, error) {
+	var header StickerHubHeader
 	data, err := sh.getHeaderData()
 	if err != nil {
-		return fmt.Errorf("get header data: %s", err)
+		return nil, fmt.Errorf("get header data: %s", err)
 	}
 	if len(data) < HubSignatureLength {
-		return fmt.Errorf("data is shorter than signature: %s", ErrNotStickerHub)
+		return nil, fmt.Errorf("data is shorter than signature: %s", ErrNotStickerHub)
 	}
 	signature := data[:HubSignatureLength]
 	if string(signature) != HubSignature {
-		return fmt.Errorf("invalid or nonexistent signature: %s", ErrNotStickerHub)
+		return nil, fmt.Errorf("invalid or nonexistent signature: %s", ErrNotStickerHub)
+	}
+	// hubs created before set chaining store a bare list of entries
+	if len(data) > HubSignatureLength && data[HubSignatureLength] == '[' {
+		err = json.Unmarshal(data[HubSignatureLength:], &header.Files)
+	} else {
+		err = json.Unmarshal(data[HubSignatureLength:], &header)
 	}
-	err = json.Unmarshal(data[HubSignatureLength:], &sh.info)
 	if err != nil {
-		return fmt.Errorf("json decode Hub Info: %s", ErrNotStickerHub)
+		return nil, fmt.Errorf("json decode Hub Info: %s", ErrNotStickerHub)
 	}
-	return nil
+	sh.info = header.Files
+	sh.chunks = header.Chunks
+	if sh.chunks == nil {
+		sh.rebuildChunks()
+	}
+	return header.Sets, nil
 }
 
 func (sh* StickerHub) RefetchSet() error {
-	return sh.FromExistingSet(sh.telegramSet.Name)
+	return sh.FromExistingSet(sh.Name())
 }
 
 func (sh* StickerHub) GetAndParseAll() error {
-	for _, s := range(sh.telegramSet.Stickers) {
-		data, err := sh.GetFile(s.FileId)
+	for _, s := range(sh.stickers) {
+		data, err := sh.GetFile(s.FileId, sh.headerCarrier())
 		if err != nil {
 			return err
 		}
@@ -238,16 +1119,34 @@ func (sh* StickerHub) GetAndParseAll() error {
 }
 
 func (sh* StickerHub) FromExistingSet(name string) error {
-	var err error
-	sh.telegramSet, err = getStickerSet(name)
+	set, err := sh.bot.GetStickerSet(name)
 	if err != nil {
 		return fmt.Errorf("get sticker set: %s", err)
 	}
-	sh.fileCount = len(sh.telegramSet.Stickers)
-	sh.userId = sh.userId
-	err = sh.parseHeader()
+	sh.sets = []telegram.StickerSet{ set }
+	sh.stickers = append([]telegram.Sticker{}, set.Stickers...)
+	sh.fileCount = len(sh.stickers)
+	chain, err := sh.parseHeader()
 	if err != nil {
 		return fmt.Errorf("parse header: %s", err)
 	}
+	for _, n := range(chain) {
+		if n == name {
+			continue
+		}
+		set, err = sh.bot.GetStickerSet(n)
+		if err != nil {
+			return fmt.Errorf("get sticker set: %s", err)
+		}
+		sh.sets = append(sh.sets, set)
+		sh.stickers = append(sh.stickers, set.Stickers...)
+	}
+	sh.fileCount = len(sh.stickers)
+	// entries written before chunking hold one sticker each, in set order
+	for i := range(sh.info) {
+		if len(sh.info[i].Stickers) == 0 && i + 1 < len(sh.stickers) {
+			sh.info[i].Stickers = []string{ sh.stickers[i + 1].UniqueId }
+		}
+	}
 	return nil
 }
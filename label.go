@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+func labelHeight(lines []string) int {
+	if len(lines) == 0 {
+		return 0
+	}
+	return len(lines) * basicfont.Face7x13.Height + LabelPadding * 2
+}
+
+// keeps the end of the line, which usually holds the extension
+func fitLabelLine(line string, width int) string {
+	chars := (width - LabelPadding * 2) / basicfont.Face7x13.Advance
+	runes := []rune(line)
+	if len(runes) <= chars || chars < 3 {
+		return line
+	}
+	return ".." + string(runes[len(runes) - chars + 2:])
+}
+
+// renders lines in white into the color channels at the bottom of the image,
+// leaving the alpha of the first used pixels alone as they hold the payload.
+// pixels past it are made opaque, so the label reads as a black box
+func drawLabel(img *image.NRGBA, used int, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	face := basicfont.Face7x13
+	w := img.Rect.Dx()
+	h := img.Rect.Dy()
+	top := max(0, h - labelHeight(lines))
+	mask := image.NewAlpha(image.Rect(0, top, w, h))
+	d := font.Drawer{ Dst: mask, Src: image.Opaque, Face: face }
+	for i, line := range(lines) {
+		d.Dot = fixed.P(LabelPadding, top + LabelPadding + i * face.Height + face.Ascent)
+		d.DrawString(fitLabelLine(line, w))
+	}
+	for y := top; y < h; y++ {
+		for x := range(w) {
+			i := img.PixOffset(x, y)
+			v := mask.AlphaAt(x, y).A
+			img.Pix[i] = v
+			img.Pix[i + 1] = v
+			img.Pix[i + 2] = v
+			if y * w + x >= used {
+				img.Pix[i + 3] = 0xFF
+			}
+		}
+	}
+}
+
+func formatSize(n int) string {
+	units := []string{ "B", "KB", "MB", "GB" }
+	size := float64(n)
+	unit := 0
+	for size >= 1024 && unit < len(units) - 1 {
+		size /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", n, units[0])
+	}
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
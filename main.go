@@ -4,83 +4,361 @@ import (
 	"fmt"
 	"strconv"
 	"errors"
+	"io"
 	"os"
+	"slices"
+	"sort"
+	"github.com/sergeykochiev/tgsh/telegram"
 )
 
 func usage() {
 	fmt.Println("Usage:")
 	fmt.Println("-", "set", "<user id> <sticker set name | \"new\">", ":", "configure hub")
-	fmt.Println("-", "put", "<filename>", ":", "put file into hub")
-	fmt.Println("-", "list", ":", "list files in hub")
-	fmt.Println("-", "get", "<file index>", ":", "download file from hub by index")
+	fmt.Println("-", "put", "<filename | -> [--name <name>] [--carrier <alpha | tgs> | --cover <image>] [--pool <regular | custom_emoji>] [--parity <count>]", ":", "put file (or stdin) into hub")
+	fmt.Println("-", "list", "[--sets]", ":", "list files in hub, or the stickers of each by their keywords and the header, and those of no file")
+	fmt.Println("-", "retag", ":", "tag stickers of older files with emoji and keywords")
+	fmt.Println("-", "rename", "<title>", ":", "change the title of the hub's sets")
+	fmt.Println("-", "get", "<file index | name>", ":", "download file from hub")
+	fmt.Println("-", "cat", "<file index | name>", ":", "write file from hub to stdout")
+	fmt.Println("-", "scrub", ":", "check every sticker of hub and its replicas, repairing damaged ones from parity or replicas")
+	fmt.Println("-", "shell", ":", "start interactive shell")
+	fmt.Println("-", "replica", "list", ":", "list replicas of hub")
+	fmt.Println("-", "replica", "add", "[<sticker set name>] [--token-env <variable> | --token-file <path> | --token-command <command>]", ":", "copy hub to a new (or existing) set, optionally owned by another bot")
+	fmt.Println("-", "replica", "sync", ":", "copy files missing or unreadable in some replica from one that has them")
+	fmt.Println("-", "replica", "rm", "<sticker set name>", ":", "forget replica")
+	fmt.Println("-", "login", "[--token-file <path> | --token-command <command> | --store-in-config]", ":", "validate and save bot token for hub")
+	fmt.Println("-", "hub", "list", ":", "list configured hubs")
+	fmt.Println("-", "hub", "add", "<name> [<user id> <sticker set name | \"new\">] [--token-env <variable>]", ":", "add hub")
+	fmt.Println("-", "hub", "use", "<name>", ":", "make hub the default one")
+	fmt.Println("-", "hub", "rm", "<name>", ":", "forget hub")
+	fmt.Println("Every command accepts --hub <name> to use a hub other than the default one")
+	fmt.Println("and --config <path> to use a config other than $XDG_CONFIG_HOME/tgsh/config.json")
 }
 
-func cmdset(c *Config, sh *StickerHub, argc int, argv []string) error {
-	var err error
-	if argc < 4 {
-		usage()
-		return nil
+// opens the hub along with its replicas. if the hub itself cannot be
+// opened, the first replica that can takes its place
+func openHub(p *HubProfile) (*StickerHub, error) {
+	sh, err := openProfile(p)
+	if !p.IsConfigured() || len(p.Replicas) == 0 {
+		return sh, err
+	}
+	var replicas []*StickerHub
+	for _, r := range(p.Replicas) {
+		rsh, rerr := openProfile(p.replicaProfile(r))
+		if rerr != nil {
+			fmt.Fprintf(os.Stderr, "Replica \"%s\" is unavailable: %s\n", r.SetName, rerr)
+			continue
+		}
+		replicas = append(replicas, rsh)
+	}
+	if err != nil {
+		if len(replicas) == 0 {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "%s, using replica \"%s\" read-only\n", err, replicas[0].Name())
+		sh, replicas = replicas[0], replicas[1:]
+		sh.StandInFor(p.SetName)
+	}
+	for _, r := range(replicas) {
+		sh.WithReplica(r)
+	}
+	return sh, nil
+}
+
+func openProfile(p *HubProfile) (*StickerHub, error) {
+	var sh StickerHub
+	token, err := resolveToken(p)
+	if err != nil {
+		return nil, err
+	}
+	sh.WithBot(telegram.NewBot(token))
+	err = sh.GetUsername()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get bot username: %s", err)
+	}
+	sh.WithEmoji(p.Options.Emoji)
+	err = sh.WithCarrier(p.Options.Carrier, p.Options.Format)
+	if err != nil {
+		return nil, err
+	}
+	err = sh.WithPool(p.Options.Pool)
+	if err != nil {
+		return nil, err
+	}
+	err = sh.WithParity(p.Options.ParityGroup, p.Options.Parity)
+	if err != nil {
+		return nil, err
+	}
+	if p.IsConfigured() {
+		sh.OfUser(p.UserId)
+		err = sh.FromExistingSet(p.SetName)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open hub: %s", err)
+		}
 	}
-	c.UserId, err = strconv.Atoi(argv[2])
+	return &sh, nil
+}
+
+func configureHub(c *Config, sh *StickerHub, userId string, setName string) error {
+	id, err := strconv.Atoi(userId)
 	if err != nil {
 		return errors.New("Unparsable user id")
 	}
-	err = sh.OfUser(c.UserId)
+	err = sh.OfUser(id)
 	if err != nil {
 		return errors.New("Invalid user id")
 	}
-	if argv[3] == "new" {
+	err = sh.CheckUser()
+	if err != nil {
+		return err
+	}
+	if setName == "new" {
 		err = sh.FromNewSet(promptString("Title:"))
 	} else {
-		err = sh.FromExistingSet(argv[3])
+		err = sh.FromExistingSet(setName)
 	}
 	if err != nil {
 	  return err
 	}
-	c.SetName = sh.telegramSet.Name
-	c.WriteFile()
-	return nil
+	p := c.Hub()
+	p.UserId = id
+	p.SetName = sh.Name()
+	p.Sets = sh.SetNames()
+	return c.WriteFile()
+}
+
+func cmdset(c *Config, sh *StickerHub, argc int, argv []string) error {
+	if argc < 4 {
+		usage()
+		return nil
+	}
+	return configureHub(c, sh, argv[2], argv[3])
 }
 
 func cmdput(c *Config, sh *StickerHub, argc int, argv []string) error {
-	if !c.IsConfigured() {
+	if !c.Hub().IsConfigured() {
 		return errors.New("Use set to configure first")
 	}
-	if argc < 3 {
+	argv, name, hasName := extractFlag(argv, "--name")
+	argv, carrier, hasCarrier := extractFlag(argv, "--carrier")
+	argv, pool, hasPool := extractFlag(argv, "--pool")
+	argv, cover, hasCover := extractFlag(argv, "--cover")
+	argv, parity, hasParity := extractFlag(argv, "--parity")
+	if len(argv) < 3 {
 		usage()
 		return nil
 	}
-	return sh.UploadFile(argv[2])
+	if hasCarrier {
+		if err := sh.WithCarrier(carrier, c.Hub().Options.Format); err != nil {
+			return err
+		}
+	}
+	if hasCover {
+		if err := sh.WithCover(cover, c.Hub().Options.Format); err != nil {
+			return err
+		}
+	}
+	if hasPool {
+		if err := sh.WithPool(pool); err != nil {
+			return err
+		}
+	}
+	if hasParity {
+		count, err := strconv.Atoi(parity)
+		if err != nil {
+			return fmt.Errorf("invalid parity count \"%s\"", parity)
+		}
+		if err := sh.WithParity(c.Hub().Options.ParityGroup, count); err != nil {
+			return err
+		}
+	}
+	if argv[2] != "-" {
+		if !hasName {
+			name = argv[2]
+		}
+		return sh.UploadFile(argv[2], name)
+	}
+	if !hasName || name == "" {
+		return errors.New("Provide --name when reading from stdin")
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("read stdin: %s", err)
+	}
+	return sh.UploadData(name, data)
 }
 
-func cmdlist(c *Config, sh *StickerHub) error {
-	if !c.IsConfigured() {
+func cmdlist(c *Config, sh *StickerHub, argv []string) error {
+	if !c.Hub().IsConfigured() {
 		return errors.New("Use set to configure first")
 	}
+	if slices.Contains(argv, "--sets") {
+		sh.ListStickerGroups()
+		return nil
+	}
 	sh.ListFiles()
 	return nil
 }
 
+func cmdrename(c *Config, sh *StickerHub, argc int, argv []string) error {
+	if !c.Hub().IsConfigured() {
+		return errors.New("Use set to configure first")
+	}
+	if argc < 3 {
+		usage()
+		return nil
+	}
+	return sh.Rename(argv[2])
+}
+
+func cmdretag(c *Config, sh *StickerHub) error {
+	if !c.Hub().IsConfigured() {
+		return errors.New("Use set to configure first")
+	}
+	return sh.Retag()
+}
+
 func cmdget(c *Config, sh *StickerHub, argc int, argv []string) error {
-	if !c.IsConfigured() {
+	if !c.Hub().IsConfigured() {
 		return errors.New("Use set to configure first")
 	}
 	if argc < 3 {
 		usage()
 		return nil
 	}
-	index, err := strconv.Atoi(argv[2])
+	index, err := sh.FindFile(argv[2])
 	if err != nil {
-		return errors.New("Unparsable file index")
+		return err
+	}
+	file, err := sh.ReadFile(index)
+	if err != nil {
+		return err
 	}
-	if index > sh.fileCount - 1 || index <= 0 {
-		return errors.New("Invalid index")
+	return os.WriteFile(sh.GetInfoEntry(index).Filename, file, 0644)
+}
+
+func cmdcat(c *Config, sh *StickerHub, argc int, argv []string) error {
+	if !c.Hub().IsConfigured() {
+		return errors.New("Use set to configure first")
+	}
+	if argc < 3 {
+		usage()
+		return nil
+	}
+	index, err := sh.FindFile(argv[2])
+	if err != nil {
+		return err
 	}
-	file, err := sh.GetFile(sh.telegramSet.Stickers[index].FileId)
+	file, err := sh.ReadFile(index)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(sh.GetInfoEntry(index - 1).Filename, file, 0644)
+	_, err = os.Stdout.Write(file)
+	return err
+}
+
+func cmdscrub(c *Config, sh *StickerHub) error {
+	if !c.Hub().IsConfigured() {
+		return errors.New("Use set to configure first")
+	}
+	return sh.Scrub()
+}
+
+func cmdshell(c *Config, sh *StickerHub) error {
+	if !c.Hub().IsConfigured() {
+		return errors.New("Use set to configure first")
+	}
+	s := shell{ c: c, sh: sh }
+	return s.run()
+}
+
+func cmdreplica(c *Config, sh *StickerHub, argc int, argv []string) error {
+	if !c.Hub().IsConfigured() {
+		return errors.New("Use set to configure first")
+	}
+	argv, env, hasEnv := extractFlag(argv, "--token-env")
+	argv, path, hasPath := extractFlag(argv, "--token-file")
+	argv, command, hasCommand := extractFlag(argv, "--token-command")
+	argc = len(argv)
+	if argc < 3 {
+		usage()
+		return nil
+	}
+	p := c.Hub()
+	switch argv[2] {
+	case "list":
+		if len(p.Replicas) == 0 {
+			fmt.Println("No replicas configured")
+			return nil
+		}
+		for _, r := range(p.Replicas) {
+			bot := "hub bot"
+			if r.hasToken() {
+				bot = "own bot"
+			}
+			rsh, ok := sh.Replica(r.SetName)
+			if !ok {
+				fmt.Println(r.SetName, bot, "(unavailable)")
+				continue
+			}
+			fmt.Println(r.SetName, bot, fmt.Sprintf("(%d files)", len(rsh.Files())))
+		}
+		return nil
+	case "add":
+		if argc > 4 {
+			usage()
+			return nil
+		}
+		var r ReplicaProfile
+		switch {
+		case hasEnv:
+			r.TokenEnv = env
+		case hasPath:
+			r.TokenFile = path
+		case hasCommand:
+			r.TokenCommand = command
+		}
+		rsh, err := openProfile(p.replicaProfile(r))
+		if err != nil {
+			return err
+		}
+		rsh.OfUser(p.UserId)
+		if err = rsh.CheckUser(); err != nil {
+			return err
+		}
+		if argc == 4 {
+			err = rsh.FromExistingSet(argv[3])
+		} else {
+			err = rsh.FromNewSet(sh.Title())
+		}
+		if err != nil {
+			return err
+		}
+		r.SetName = rsh.Name()
+		p.Replicas = append(p.Replicas, r)
+		if err = c.WriteFile(); err != nil {
+			return err
+		}
+		sh.WithReplica(rsh)
+		return sh.SyncReplicas()
+	case "sync":
+		return sh.SyncReplicas()
+	case "rm":
+		if argc < 4 {
+			usage()
+			return nil
+		}
+		for i, r := range(p.Replicas) {
+			if r.SetName == argv[3] {
+				p.Replicas = append(p.Replicas[:i], p.Replicas[i + 1:]...)
+				return c.WriteFile()
+			}
+		}
+		return fmt.Errorf("no replica in set \"%s\"", argv[3])
+	default:
+		usage()
+		return nil
+	}
 }
 
 func cmd(c *Config, sh *StickerHub, argc int, argv []string) error {
@@ -88,41 +366,203 @@ func cmd(c *Config, sh *StickerHub, argc int, argv []string) error {
 		usage()
 		return nil
 	}
-	switch os.Args[1] {
+	switch argv[1] {
 	case "set": return cmdset(c, sh, argc, argv);
 	case "get": return cmdget(c, sh, argc, argv);
+	case "cat": return cmdcat(c, sh, argc, argv);
 	case "put": return cmdput(c, sh, argc, argv);
-	case "list": return cmdlist(c, sh);
+	case "list": return cmdlist(c, sh, argv);
+	case "retag": return cmdretag(c, sh);
+	case "rename": return cmdrename(c, sh, argc, argv);
+	case "scrub": return cmdscrub(c, sh);
+	case "shell": return cmdshell(c, sh);
+	case "replica": return cmdreplica(c, sh, argc, argv);
+	default:
+		usage()
+		return nil
+	}
+}
+
+func cmdhub(c *Config, argc int, argv []string) error {
+	argv, env, hasEnv := extractFlag(argv, "--token-env")
+	argc = len(argv)
+	if argc < 3 {
+		usage()
+		return nil
+	}
+	switch argv[2] {
+	case "list":
+		if len(c.Hubs) == 0 {
+			fmt.Println("No hubs configured")
+			return nil
+		}
+		names := make([]string, 0, len(c.Hubs))
+		for name := range(c.Hubs) {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range(names) {
+			p := c.Hubs[name]
+			mark := " "
+			if name == c.Current {
+				mark = "*"
+			}
+			if !p.IsConfigured() {
+				fmt.Println(mark, name, "(not configured)")
+				continue
+			}
+			fmt.Println(mark, name, "user", p.UserId, "set", p.SetName, fmt.Sprintf("(%d sets)", max(len(p.Sets), 1)))
+		}
+		return nil
+	case "add":
+		if argc != 4 && argc != 6 {
+			usage()
+			return nil
+		}
+		p, err := c.AddHub(argv[3])
+		if err != nil {
+			return err
+		}
+		if hasEnv {
+			p.TokenEnv = env
+		}
+		if argc == 4 {
+			return c.WriteFile()
+		}
+		c.Select(argv[3])
+		sh, err := openHub(p)
+		if err != nil {
+			return err
+		}
+		return configureHub(c, sh, argv[4], argv[5])
+	case "use":
+		if argc < 4 {
+			usage()
+			return nil
+		}
+		if err := c.UseHub(argv[3]); err != nil {
+			return err
+		}
+		return c.WriteFile()
+	case "rm":
+		if argc < 4 {
+			usage()
+			return nil
+		}
+		if err := c.RemoveHub(argv[3]); err != nil {
+			return err
+		}
+		return c.WriteFile()
 	default:
 		usage()
 		return nil
 	}
 }
 
+func cmdlogin(c *Config, argc int, argv []string) error {
+	argv, command, hasCommand := extractFlag(argv, "--token-command")
+	argv, path, hasPath := extractFlag(argv, "--token-file")
+	inConfig := slices.Contains(argv, "--store-in-config")
+	p := c.Hub()
+	var token string
+	var err error
+	switch {
+	case hasCommand:
+		token, err = runTokenCommand(command)
+	case hasPath:
+		token, err = readTokenFile(path)
+	default:
+		token, err = readSecret("Bot token: ")
+	}
+	if err != nil {
+		return fmt.Errorf("read token: %s", err)
+	}
+	user, err := telegram.NewBot(token).GetMe()
+	if err != nil {
+		return fmt.Errorf("Token was rejected by Telegram: %s", err)
+	}
+	// other sources would take precedence over the token just checked
+	p.TokenEnv, p.TokenCommand, p.TokenFile, p.Token = "", "", "", ""
+	switch {
+	case hasCommand:
+		p.TokenCommand = command
+	case hasPath:
+		p.TokenFile = path
+	case inConfig:
+		p.Token = token
+	default:
+		p.TokenFile, err = defaultTokenFile(c.Path(), c.HubName())
+		if err != nil {
+			return err
+		}
+		err = writeTokenFile(p.TokenFile, token)
+		if err != nil {
+			return fmt.Errorf("write token file: %s", err)
+		}
+	}
+	fmt.Printf("Logged in as @%s for hub \"%s\"\n", user.Username, c.HubName())
+	return c.WriteFile()
+}
+
 func main() {
 	var c Config
+	argv, configPath, _ := extractFlag(os.Args, "--config")
+	argv, hubName, _ := extractFlag(argv, "--hub")
+	argc := len(argv)
+
+	c.SetPath(configPath)
 	err := c.GetOrCreate()
 	if err != nil {
-		fmt.Println("Failed to get config:", err)
+		fmt.Fprintln(os.Stderr, "Failed to get config:", err)
+		os.Exit(1)
+	}
+
+	if argc > 1 && argv[1] == "hub" {
+		err = cmdhub(&c, argc, argv)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 		return
 	}
 
-	var sh StickerHub
-	err = sh.GetUsername()
+	err = c.Select(hubName)
 	if err != nil {
-		fmt.Println("Failed to get bot username:", err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if argc > 1 && argv[1] == "login" {
+		err = cmdlogin(&c, argc, argv)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 		return
 	}
 
-	if c.IsConfigured() {
-		sh.OfUser(c.UserId)
-		sh.FromExistingSet(c.SetName)
+	sh, err := openHub(c.Hub())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	argc := len(os.Args)
+	// errors go to stderr so they never end up in the output of cat
+	err = cmd(&c, sh, argc, argv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
 
-	err = cmd(&c, &sh, argc, os.Args)
+	p := c.Hub()
+	// a replica standing in for the hub has sets of its own
+	if p.IsConfigured() && sh.Name() == p.SetName && !slices.Equal(p.Sets, sh.SetNames()) {
+		p.Sets = sh.SetNames()
+		if werr := c.WriteFile(); werr != nil {
+			fmt.Fprintln(os.Stderr, "Failed to save config:", werr)
+			err = werr
+		}
+	}
 	if err != nil {
-		fmt.Println(err)
+		os.Exit(1)
 	}
 }
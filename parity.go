@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"github.com/sergeykochiev/tgsh/reedsolomon"
+)
+
+func chunkChecksum(data []byte) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(data))
+}
+
+// number of data stickers of a file, which all are data stickers
+// for entries written before chunk sizes were recorded
+func (e StickerHubInfoEntry) chunkCount() int {
+	if len(e.Hashes) > 0 {
+		return len(e.Hashes)
+	}
+	if e.ChunkSize == 0 {
+		return len(e.Stickers)
+	}
+	return max(1, (e.Size + e.ChunkSize - 1) / e.ChunkSize)
+}
+
+// number of stickers the layout of a file takes
+func (e StickerHubInfoEntry) stickerCount() int {
+	count := e.chunkCount()
+	if e.Parity == 0 {
+		return count
+	}
+	return count + (count + e.Group - 1) / e.Group * e.Parity
+}
+
+// what each sticker of a file is labeled with besides the name and size
+func (e StickerHubInfoEntry) stickerLabels() []string {
+	count := e.stickerCount()
+	chunks := e.chunkCount()
+	labels := make([]string, 0, count)
+	dataIndex, parityIndex := 0, 0
+	for i := range(count) {
+		if e.isParity(i) {
+			parityIndex++
+			labels = append(labels, fmt.Sprintf("parity %d/%d", parityIndex, count - chunks))
+		} else {
+			dataIndex++
+			labels = append(labels, fmt.Sprintf("chunk %d/%d", dataIndex, chunks))
+		}
+	}
+	return labels
+}
+
+// payloads of the stickers of a file in the layout of its entry
+func filePayloads(e StickerHubInfoEntry, data []byte) ([][]byte, error) {
+	if e.ChunkSize == 0 {
+		return nil, errors.New("entry has no recorded chunk size")
+	}
+	if len(data) != e.Size {
+		return nil, fmt.Errorf("file is %d bytes instead of %d", len(data), e.Size)
+	}
+	var chunks [][]byte
+	if len(e.Hashes) > 0 {
+		chunks = contentChunks(data, e.ChunkSize)
+		if len(chunks) != len(e.Hashes) {
+			return nil, fmt.Errorf("file splits into %d chunks instead of %d", len(chunks), len(e.Hashes))
+		}
+	} else {
+		for i := range(e.chunkCount()) {
+			chunks = append(chunks, data[i * e.ChunkSize:min(len(data), (i + 1) * e.ChunkSize)])
+		}
+	}
+	return addParity(chunks, e.Group, e.Parity)
+}
+
+// stickers of a file stored with parity come in groups of up to Group data
+// stickers followed by Parity parity stickers, only the last group is short
+func (e StickerHubInfoEntry) isParity(i int) bool {
+	if e.Parity == 0 {
+		return false
+	}
+	per := e.Group + e.Parity
+	return i % per >= min(e.Group, e.chunkCount() - i / per * e.Group)
+}
+
+// shards of a group with the data padded to size, counting data stickers
+// a short group lacks as zeroes. parity shards are left nil
+func groupShards(data [][]byte, group int, parity int, size int) [][]byte {
+	shards := make([][]byte, group + parity)
+	for i := range(group) {
+		shards[i] = make([]byte, size)
+		if i < len(data) {
+			copy(shards[i], data[i])
+		}
+	}
+	return shards
+}
+
+// appends the parity chunks of every group after its data chunks
+func addParity(chunks [][]byte, group int, parity int) ([][]byte, error) {
+	if parity == 0 {
+		return chunks, nil
+	}
+	code, err := reedsolomon.New(group, parity)
+	if err != nil {
+		return nil, err
+	}
+	var out [][]byte
+	for start := 0; start < len(chunks); start += group {
+		data := chunks[start:min(len(chunks), start + group)]
+		size := 0
+		for _, d := range(data) {
+			size = max(size, len(d))
+		}
+		shards := groupShards(data, group, parity, size)
+		if err = code.Encode(shards); err != nil {
+			return nil, err
+		}
+		out = append(out, data...)
+		out = append(out, shards[group:]...)
+	}
+	return out, nil
+}
+
+// fills in the missing data shards of a group with dataCount data stickers
+func recoverGroup(code *reedsolomon.Code, shards [][]byte, dataCount int) error {
+	size := -1
+	for _, s := range(shards[code.DataShards():]) {
+		if s != nil {
+			size = len(s)
+			break
+		}
+	}
+	if size < 0 {
+		return errors.New("no parity stickers left")
+	}
+	for i := range(code.DataShards()) {
+		if i >= dataCount {
+			shards[i] = make([]byte, size)
+		} else if shards[i] != nil {
+			padded := make([]byte, size)
+			copy(padded, shards[i])
+			shards[i] = padded
+		}
+	}
+	return code.Reconstruct(shards)
+}
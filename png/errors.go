@@ -0,0 +1,31 @@
+package png
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrBadSignature = errors.New("invalid signature")
+	ErrBadCRC = errors.New("CRC mismatch")
+	ErrTruncatedChunk = errors.New("truncated chunk")
+	ErrBadZlib = errors.New("bad zlib stream")
+	ErrTooLarge = errors.New("image is too large")
+	ErrFormat = errors.New("invalid format")
+)
+
+// wraps any error that occured while reading a chunk,
+// so errors.Is still matches the underlying error
+type ChunkError struct {
+	Type string
+	Offset int
+	Err error
+}
+
+func (e ChunkError) Error() string {
+	return fmt.Sprintf("chunk %q at offset %d: %s", e.Type, e.Offset, e.Err)
+}
+
+func (e ChunkError) Unwrap() error {
+	return e.Err
+}
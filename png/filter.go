@@ -0,0 +1,99 @@
+package png
+
+import (
+	"fmt"
+)
+
+const (
+	PngFilter_None byte = 0
+	PngFilter_Sub byte = 1
+	PngFilter_Up byte = 2
+	PngFilter_Average byte = 3
+	PngFilter_Paeth byte = 4
+)
+
+// picks the filter with the smallest sum of absolute differences per row
+const PngFilter_Adaptive int = -1
+
+const pngFilterCount = 5
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa := p - int(a)
+	if pa < 0 { pa = -pa }
+	pb := p - int(b)
+	if pb < 0 { pb = -pb }
+	pc := p - int(c)
+	if pc < 0 { pc = -pc }
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+// bpp is the distance in bytes to the corresponding byte of the previous pixel,
+// prev is the unfiltered previous row (all zeroes for the first one)
+func filterRow(dst, row, prev []byte, filter byte, bpp int) {
+	for i := range(row) {
+		var a, c byte
+		if i >= bpp {
+			a = row[i - bpp]
+			c = prev[i - bpp]
+		}
+		b := prev[i]
+		switch filter {
+		case PngFilter_None: dst[i] = row[i]
+		case PngFilter_Sub: dst[i] = row[i] - a
+		case PngFilter_Up: dst[i] = row[i] - b
+		case PngFilter_Average: dst[i] = row[i] - byte((int(a) + int(b)) / 2)
+		case PngFilter_Paeth: dst[i] = row[i] - paeth(a, b, c)
+		}
+	}
+}
+
+func unfilterRow(row, prev []byte, filter byte, bpp int) error {
+	for i := range(row) {
+		var a, c byte
+		if i >= bpp {
+			a = row[i - bpp]
+			c = prev[i - bpp]
+		}
+		b := prev[i]
+		switch filter {
+		case PngFilter_None:
+		case PngFilter_Sub: row[i] += a
+		case PngFilter_Up: row[i] += b
+		case PngFilter_Average: row[i] += byte((int(a) + int(b)) / 2)
+		case PngFilter_Paeth: row[i] += paeth(a, b, c)
+		default: return fmt.Errorf("invalid filter type %d", filter)
+		}
+	}
+	return nil
+}
+
+func filterScore(row []byte) int {
+	score := 0
+	for _, b := range(row) {
+		v := int(int8(b))
+		if v < 0 { v = -v }
+		score += v
+	}
+	return score
+}
+
+func chooseFilter(candidates [pngFilterCount][]byte, row, prev []byte, bpp int) byte {
+	best := PngFilter_None
+	bestScore := -1
+	for f := range(pngFilterCount) {
+		filterRow(candidates[f], row, prev, byte(f), bpp)
+		score := filterScore(candidates[f])
+		if bestScore < 0 || score < bestScore {
+			best = byte(f)
+			bestScore = score
+		}
+	}
+	return best
+}
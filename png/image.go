@@ -0,0 +1,293 @@
+package png
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// makes image.Decode use this package for PNG. image/png registers the same
+// signature, so this is left to programs that do not import it
+func Register() {
+	image.RegisterFormat("png", string(PngSignature), Decode, DecodeConfig)
+}
+
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var p PngImage
+	_, err = p.From(data)
+	if err != nil {
+		return nil, err
+	}
+	return p.Image()
+}
+
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	var p PngImage
+	_, err = p.readChunks(data, PngIDAT)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: p.colorModel(),
+		Width: int(p.w),
+		Height: int(p.h),
+	}, nil
+}
+
+func Encode(w io.Writer, img image.Image) error {
+	p, err := FromImage(img)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(p.Encode())
+	return err
+}
+
+func (p PngImage) colorModel() color.Model {
+	switch {
+	case p.colorType == PngCT_Palette:
+		return p.colorPalette()
+	case p.colorType == PngCT_Grayscale && len(p.Transparency) == 0:
+		if p.depth == 16 {
+			return color.Gray16Model
+		}
+		return color.GrayModel
+	case p.depth == 16:
+		return color.NRGBA64Model
+	default:
+		return color.NRGBAModel
+	}
+}
+
+func (p PngImage) colorPalette() color.Palette {
+	pal := make(color.Palette, len(p.Palette) / 3)
+	for i := range(pal) {
+		a := byte(0xFF)
+		if i < len(p.Transparency) {
+			a = p.Transparency[i]
+		}
+		pal[i] = color.NRGBA{ p.Palette[i * 3], p.Palette[i * 3 + 1], p.Palette[i * 3 + 2], a }
+	}
+	return pal
+}
+
+// i-th sample of a row, not scaled
+func (p PngImage) sample(row []byte, i int) uint16 {
+	switch p.depth {
+	case 16:
+		return binary.BigEndian.Uint16(row[i * 2:])
+	case 8:
+		return uint16(row[i])
+	default:
+		bit := i * int(p.depth)
+		return uint16(row[bit / 8] >> (8 - int(p.depth) - bit % 8)) & (1 << p.depth - 1)
+	}
+}
+
+// scales a sample to 16 bits
+func (p PngImage) sample16(row []byte, i int) uint16 {
+	v := p.sample(row, i)
+	if p.depth == 16 {
+		return v
+	}
+	return uint16(uint32(v) * 0xFFFF / (1 << p.depth - 1))
+}
+
+// single color marked transparent by tRNS in grayscale and RGB images
+func (p PngImage) isTransparentKey(row []byte, x int) bool {
+	channels := PngCTSizeMap[p.colorType]
+	if len(p.Transparency) < channels * 2 {
+		return false
+	}
+	for c := range(channels) {
+		if p.sample(row, x * channels + c) != binary.BigEndian.Uint16(p.Transparency[c * 2:]) {
+			return false
+		}
+	}
+	return true
+}
+
+// converts decoded image data to the closest standard library image type
+func (p PngImage) Image() (image.Image, error) {
+	if !p.IsFullOfData() {
+		return nil, fmt.Errorf("%w: image data does not match dimensions", ErrFormat)
+	}
+	w := int(p.w)
+	h := int(p.h)
+	rect := image.Rect(0, 0, w, h)
+	rowSize := p.RowSize()
+	if p.colorType == PngCT_Palette {
+		img := image.NewPaletted(rect, p.colorPalette())
+		for y := range(h) {
+			row := p.ImageData[y * rowSize:]
+			for x := range(w) {
+				idx := p.sample(row, x)
+				if int(idx) >= len(img.Palette) {
+					return nil, fmt.Errorf("%w: palette index %d out of range", ErrFormat, idx)
+				}
+				img.Pix[y * img.Stride + x] = byte(idx)
+			}
+		}
+		return img, nil
+	}
+	if p.colorType == PngCT_Grayscale && len(p.Transparency) == 0 {
+		if p.depth == 16 {
+			img := image.NewGray16(rect)
+			copy(img.Pix, p.ImageData)
+			return img, nil
+		}
+		img := image.NewGray(rect)
+		for y := range(h) {
+			row := p.ImageData[y * rowSize:]
+			for x := range(w) {
+				img.Pix[y * img.Stride + x] = byte(p.sample16(row, x) >> 8)
+			}
+		}
+		return img, nil
+	}
+	if p.colorType == PngCT_RGB_Alpha {
+		if p.depth == 16 {
+			img := image.NewNRGBA64(rect)
+			copy(img.Pix, p.ImageData)
+			return img, nil
+		}
+		img := image.NewNRGBA(rect)
+		copy(img.Pix, p.ImageData)
+		return img, nil
+	}
+	// remaining types are expanded to NRGBA writing pixels directly,
+	// since converting colors through Set loses precision at low alpha
+	var pix []byte
+	var stride int
+	var img image.Image
+	if p.depth == 16 {
+		img64 := image.NewNRGBA64(rect)
+		pix, stride, img = img64.Pix, img64.Stride, img64
+	} else {
+		img8 := image.NewNRGBA(rect)
+		pix, stride, img = img8.Pix, img8.Stride, img8
+	}
+	for y := range(h) {
+		row := p.ImageData[y * rowSize:]
+		for x := range(w) {
+			var c [4]uint16
+			switch p.colorType {
+			case PngCT_Grayscale:
+				g := p.sample16(row, x)
+				c = [4]uint16{ g, g, g, 0xFFFF }
+			case PngCT_GrayscaleAlpha:
+				g := p.sample16(row, x * 2)
+				c = [4]uint16{ g, g, g, p.sample16(row, x * 2 + 1) }
+			case PngCT_RGB:
+				c = [4]uint16{ p.sample16(row, x * 3), p.sample16(row, x * 3 + 1), p.sample16(row, x * 3 + 2), 0xFFFF }
+			}
+			if p.colorType != PngCT_GrayscaleAlpha && p.isTransparentKey(row, x) {
+				c[3] = 0
+			}
+			for i, v := range(c) {
+				if p.depth == 16 {
+					binary.BigEndian.PutUint16(pix[y * stride + x * 8 + i * 2:], v)
+				} else {
+					pix[y * stride + x * 4 + i] = byte(v >> 8)
+				}
+			}
+		}
+	}
+	return img, nil
+}
+
+func copyRows(dst []byte, src []byte, stride int, rowSize int, h int) {
+	for y := range(h) {
+		copy(dst[y * rowSize:(y + 1) * rowSize], src[y * stride:])
+	}
+}
+
+// picks color type and depth matching the image, falling back to 8-bit RGBA
+func FromImage(img image.Image) (PngImage, error) {
+	var p PngImage
+	b := img.Bounds()
+	w := b.Dx()
+	h := b.Dy()
+	switch src := img.(type) {
+	case *image.Gray:
+		if err := p.Configure(uint32(w), uint32(h), PngCT_Grayscale, 8); err != nil {
+			return p, err
+		}
+		p.ImageData = make([]byte, p.DataSize())
+		copyRows(p.ImageData, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, p.RowSize(), h)
+	case *image.Gray16:
+		if err := p.Configure(uint32(w), uint32(h), PngCT_Grayscale, 16); err != nil {
+			return p, err
+		}
+		p.ImageData = make([]byte, p.DataSize())
+		copyRows(p.ImageData, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, p.RowSize(), h)
+	case *image.Paletted:
+		if len(src.Palette) > 256 {
+			return p, fmt.Errorf("%w: palette has %d colors", ErrFormat, len(src.Palette))
+		}
+		if err := p.Configure(uint32(w), uint32(h), PngCT_Palette, 8); err != nil {
+			return p, err
+		}
+		palette := make([]byte, 0, len(src.Palette) * 3)
+		transparency := make([]byte, 0, len(src.Palette))
+		for _, c := range(src.Palette) {
+			n := color.NRGBAModel.Convert(c).(color.NRGBA)
+			palette = append(palette, n.R, n.G, n.B)
+			transparency = append(transparency, n.A)
+		}
+		// trailing opaque entries can be left out of tRNS
+		for len(transparency) > 0 && transparency[len(transparency) - 1] == 0xFF {
+			transparency = transparency[:len(transparency) - 1]
+		}
+		if len(palette) == 0 {
+			palette = []byte{ 0, 0, 0 }
+		}
+		if err := p.SetPalette(palette, transparency); err != nil {
+			return p, err
+		}
+		p.ImageData = make([]byte, p.DataSize())
+		copyRows(p.ImageData, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, p.RowSize(), h)
+	case *image.NRGBA64, *image.RGBA64:
+		if err := p.Configure(uint32(w), uint32(h), PngCT_RGB_Alpha, 16); err != nil {
+			return p, err
+		}
+		p.ImageData = make([]byte, 0, p.DataSize())
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+				p.ImageData = binary.BigEndian.AppendUint16(p.ImageData, c.R)
+				p.ImageData = binary.BigEndian.AppendUint16(p.ImageData, c.G)
+				p.ImageData = binary.BigEndian.AppendUint16(p.ImageData, c.B)
+				p.ImageData = binary.BigEndian.AppendUint16(p.ImageData, c.A)
+			}
+		}
+	case *image.NRGBA:
+		if err := p.Configure(uint32(w), uint32(h), PngCT_RGB_Alpha, 8); err != nil {
+			return p, err
+		}
+		p.ImageData = make([]byte, p.DataSize())
+		copyRows(p.ImageData, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, p.RowSize(), h)
+	default:
+		if err := p.Configure(uint32(w), uint32(h), PngCT_RGB_Alpha, 8); err != nil {
+			return p, err
+		}
+		p.ImageData = make([]byte, 0, p.DataSize())
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				p.ImageData = append(p.ImageData, c.R, c.G, c.B, c.A)
+			}
+		}
+	}
+	return p, nil
+}
@@ -3,7 +3,6 @@ package png
 import (
 	"fmt"
 	"io"
-	"log"
 //"math/rand"
 	"errors"
 	"os"
@@ -27,6 +26,8 @@ const (
 	PngIHDR string = "IHDR"
 	PngIDAT string = "IDAT"
 	PngIEND string = "IEND"
+	PngPLTE string = "PLTE"
+	PngtRNS string = "tRNS"
 )
 
 const (
@@ -41,6 +42,22 @@ const PngCRCInitVal = 0x0
 
 const PngMinLength int = PngSignatureLength + int(PngChunk_BaseLen) * 2 + int(PngChunk_IHDRLen)
 
+const PngDefaultIDATSize int = 1 << 16
+
+// refuse to decode images with more raw data than this
+const PngMaxDataSize int = 1 << 28
+
+// allowed bit depths per color type
+var PngCTDepthMap map[byte][]byte = map[byte][]byte{
+	PngCT_Grayscale: {1, 2, 4, 8, 16},
+	PngCT_RGB: {8, 16},
+	PngCT_Palette: {1, 2, 4, 8},
+	PngCT_GrayscaleAlpha: {8, 16},
+	PngCT_RGB_Alpha: {8, 16},
+}
+
+// ImageData holds unfiltered scanlines, each RowSize bytes long,
+// packed according to color type and bit depth
 type PngImage struct {
 	w uint32
 	h uint32
@@ -49,34 +66,45 @@ type PngImage struct {
 	compression byte
 	filter byte
 	interlace byte
-	
+
+	level int
+	rowFilter int
+	idatSize int
+
+	compressed []byte
+
+	Palette []byte
+	Transparency []byte
 	ImageData []byte
 }
 
-func CompressZlib(data []byte) []byte {
+func CompressZlib(data []byte, level int) []byte {
 	var b bytes.Buffer
-	w := zlib.NewWriter(&b)
+	w, err := zlib.NewWriterLevel(&b, level)
+	if err != nil {
+		w = zlib.NewWriter(&b)
+	}
 	w.Write(data)
 	w.Close()
-
-	fmt.Println(b.Len())
-	
 	return b.Bytes()
 }
 
-func DecompressZlib(data []byte) []byte {
+// limit caps the decompressed size to guard against zlib bombs
+func DecompressZlib(data []byte, limit int) ([]byte, error) {
 	b := bytes.NewReader(data)
 	r, err := zlib.NewReader(b)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("%w: %s", ErrBadZlib, err)
 	}
-	output, err := io.ReadAll(r)
+	defer r.Close()
+	output, err := io.ReadAll(io.LimitReader(r, int64(limit) + 1))
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("%w: %s", ErrBadZlib, err)
 	}
-	r.Close()
-
-	return output
+	if len(output) > limit {
+		return nil, fmt.Errorf("%w: more than %d bytes of image data", ErrBadZlib, limit)
+	}
+	return output, nil
 }
 
 func ComputeCRC(crc uint32, data []byte) uint32 {
@@ -122,51 +150,109 @@ func (p PngImage) constructIEND() []byte {
 	return ConstructPngChunk([]byte{}, PngIEND, 0)
 }
 
+func (p PngImage) constructPLTE() []byte {
+	return ConstructPngChunk(p.Palette, PngPLTE, uint32(len(p.Palette)))
+}
+
+func (p PngImage) constructtRNS() []byte {
+	return ConstructPngChunk(p.Transparency, PngtRNS, uint32(len(p.Transparency)))
+}
+
+func (p PngImage) Width() int {
+	return int(p.w)
+}
+
+func (p PngImage) Height() int {
+	return int(p.h)
+}
+
+func (p PngImage) ColorType() byte {
+	return p.colorType
+}
+
+func (p PngImage) Depth() byte {
+	return p.depth
+}
+
+func (p PngImage) BitsPerPixel() int {
+	return PngCTSizeMap[p.colorType] * int(p.depth)
+}
+
+// byte distance between corresponding bytes of neighbouring pixels, as used by filters
+func (p PngImage) filterOffset() int {
+	return max(1, p.BitsPerPixel() / 8)
+}
+
+func (p PngImage) RowSize() int {
+	return (int(p.w) * p.BitsPerPixel() + 7) / 8
+}
+
 func (p PngImage) actualWidth() int {
-	return (int(p.w) * PngCTSizeMap[p.colorType] * int(p.depth / 8)) + 1
+	return p.RowSize() + 1
 }
 
-// currently uses hardcoded values for 8bit RGBA
-func (p PngImage) constructData() []byte {
-	h := int(p.h)
-	w := int(p.w)
-	lengthData := len(p.ImageData)
-	output := make([]byte, 0, h * p.actualWidth())
-
-	idx := 0
-	for range(h) {
-		output = append(output, 0)
-		for range(w) {
-			if idx >= lengthData {
-				output = append(output, 0, 0, 0, 0)
-				continue
-			}
-			output = append(output, 0, 0, 0, p.ImageData[idx])
-			idx += 1
-			// output = append(output, p.ImageData[idx])
-			// idx += 1
-			// if idx >= lengthData {
-			// 	output = append(output, 0, 0, 255)
-			// 	continue
-			// }
-			// output = append(output, p.ImageData[idx])
-			// idx += 1
-			// if idx >= lengthData {
-			// 	output = append(output, 0, 255)
-			// 	continue
-			// }
-			// output = append(output, p.ImageData[idx])
-			// idx += 1
-			// output = append(output, 255)
+func (p PngImage) DataSize() int {
+	return p.RowSize() * int(p.h)
+}
+
+// size of the filtered, possibly interlaced, image data before compression
+func (p PngImage) rawSize() int {
+	if p.interlace == 0 {
+		return p.DataSize() + int(p.h)
+	}
+	size := 0
+	for _, pass := range(adam7Passes) {
+		pw := (int(p.w) - pass.x + pass.dx - 1) / pass.dx
+		ph := (int(p.h) - pass.y + pass.dy - 1) / pass.dy
+		if pw > 0 && ph > 0 {
+			size += ((pw * p.BitsPerPixel() + 7) / 8 + 1) * ph
 		}
 	}
+	return size
+}
 
+func (p PngImage) filterRows() []byte {
+	rowSize := p.RowSize()
+	bpp := p.filterOffset()
+	data := p.ImageData
+	if len(data) < p.DataSize() {
+		data = append(data[:len(data):len(data)], make([]byte, p.DataSize() - len(data))...)
+	}
+	output := make([]byte, 0, int(p.h) * p.actualWidth())
+	prev := make([]byte, rowSize)
+	var candidates [pngFilterCount][]byte
+	for i := range(candidates) {
+		candidates[i] = make([]byte, rowSize)
+	}
+	for y := range(int(p.h)) {
+		row := data[y * rowSize:(y + 1) * rowSize]
+		var filter byte
+		if p.rowFilter == PngFilter_Adaptive {
+			filter = chooseFilter(candidates, row, prev, bpp)
+		} else {
+			filter = byte(p.rowFilter)
+			filterRow(candidates[filter], row, prev, filter, bpp)
+		}
+		output = append(output, filter)
+		output = append(output, candidates[filter]...)
+		prev = row
+	}
 	return output
 }
 
 func (p PngImage) constructIDAT() []byte {
-	data := CompressZlib(p.constructData())
-	return ConstructPngChunk(data, PngIDAT, uint32(len(data)))
+	data := CompressZlib(p.filterRows(), p.level)
+	size := p.idatSize
+	if size <= 0 {
+		size = len(data)
+	}
+	output := make([]byte, 0, len(data) + int(PngChunk_BaseLen))
+	for len(data) > 0 {
+		n := min(size, len(data))
+		output = append(output, ConstructPngChunk(data[:n], PngIDAT, uint32(n))...)
+		data = data[n:]
+	}
+	return output
 }
 
 func (p PngImage) Encode() []byte {
@@ -174,6 +260,12 @@ func (p PngImage) Encode() []byte {
 
 	output = append(output, PngSignature...)
 	output = append(output, p.constructIHDR()...)
+	if p.colorType == PngCT_Palette {
+		output = append(output, p.constructPLTE()...)
+	}
+	if len(p.Transparency) > 0 {
+		output = append(output, p.constructtRNS()...)
+	}
 	output = append(output, p.constructIDAT()...)
 	output = append(output, p.constructIEND()...)
 
@@ -181,97 +273,210 @@ func (p PngImage) Encode() []byte {
 }
 
 func deconstructSignature(data []byte) error {
-	if len(data) < PngSignatureLength {
-		return errors.New("not enough data")
-	}
-	for i := range(PngSignatureLength) {
-		if data[i] != PngSignature[i] {
-			return errors.New("invalid signature")
-		}
+	if len(data) < PngSignatureLength || !bytes.Equal(data[:PngSignatureLength], PngSignature) {
+		return ErrBadSignature
 	}
 	return nil
 }
 
 func (p* PngImage) deconstructChunk(data []byte, expectTyp *string) (int, error) {
 	if len(data) < int(PngChunk_BaseLen) {
-		return 0, errors.New("not enough data")
+		return 0, fmt.Errorf("%w: %d bytes left", ErrTruncatedChunk, len(data))
+	}
+	chunkLength := int(binary.BigEndian.Uint32(data))
+	if chunkLength > len(data) - int(PngChunk_BaseLen) {
+		return 0, fmt.Errorf("%w: length is %d, only %d bytes left", ErrTruncatedChunk, chunkLength, len(data) - int(PngChunk_BaseLen))
 	}
-	chunkLength := binary.BigEndian.Uint32(data)
 	typ := string(data[4:8])
 	if *expectTyp != "" && *expectTyp != typ {
-		return 4, fmt.Errorf("unexpected chunk type: %x; expected: %x (%s)", []byte(typ), []byte(*expectTyp), *expectTyp)
+		return 4, fmt.Errorf("%w: unexpected chunk type: %x; expected: %x (%s)", ErrFormat, []byte(typ), []byte(*expectTyp), *expectTyp)
 	} else {
 		*expectTyp = typ
-  }
-	parsedCount, err := p.deconstructChunkData(data[8:], typ, int(chunkLength))
+	}
+	crc := binary.BigEndian.Uint32(data[8 + chunkLength:])
+	if ComputeCRC(PngCRCInitVal, data[4:8 + chunkLength]) != crc {
+		return 4, ErrBadCRC
+	}
+	err := p.deconstructChunkData(data[8:8 + chunkLength], typ)
 	if err != nil {
-		return 4, fmt.Errorf("failed to deconstruct chunk of type %x: %s", []byte(typ), err)
+		return 4, err
 	}
-	return parsedCount + int(PngChunk_BaseLen), nil
+	return chunkLength + int(PngChunk_BaseLen), nil
 }
 
 func (p* PngImage) IsFullOfData() bool {
-	return len(p.ImageData) == int(p.w * p.h)
+	return len(p.ImageData) == p.DataSize()
 }
 
-func (p* PngImage) deconstructChunkData(data []byte, typ string, toParse int) (int, error) {
+// chunks with the fifth bit of the first letter set are ancillary
+func isAncillary(typ string) bool {
+	return typ[0] & 0x20 != 0
+}
+
+func (p* PngImage) deconstructChunkData(data []byte, typ string) error {
 	switch typ {
-		case PngIHDR: return int(PngChunk_IHDRLen), p.deconstructIHDRData(data)
-		case PngIDAT: return toParse, p.deconstructIDATData(data, toParse)
-		case PngIEND: return 0, nil
-		default: return 0, fmt.Errorf("unsupported or invalid chunk type")
+		case PngIHDR: return p.deconstructIHDRData(data)
+		case PngPLTE: return p.deconstructPLTEData(data)
+		case PngtRNS:
+			p.Transparency = append([]byte{}, data...)
+			return nil
+		case PngIDAT:
+			p.compressed = append(p.compressed, data...)
+			return nil
+		case PngIEND: return nil
+		default:
+			if isAncillary(typ) {
+				return nil
+			}
+			return fmt.Errorf("%w: unsupported critical chunk type", ErrFormat)
 	}
 }
 
 func (p* PngImage) deconstructIHDRData(data []byte) error {
-	if len(data) < int(PngChunk_IHDRLen) {
-		return errors.New("not enough data")
+	if len(data) != int(PngChunk_IHDRLen) {
+		return fmt.Errorf("%w: invalid IHDR length", ErrFormat)
 	}
 	p.w = binary.BigEndian.Uint32(data);
 	if p.w == 0 {
-		return errors.New("invalid width info")
+		return fmt.Errorf("%w: invalid width info", ErrFormat)
 	}
 	p.h = binary.BigEndian.Uint32(data[4:]);
 	if p.h == 0 {
-		return errors.New("invalid height info")
+		return fmt.Errorf("%w: invalid height info", ErrFormat)
 	}
 	p.depth = data[8]
 	p.colorType = data[9]
+	if int(p.colorType) >= len(PngCTSizeMap) || PngCTSizeMap[p.colorType] == 0 {
+		return fmt.Errorf("%w: invalid color type %d", ErrFormat, p.colorType)
+	}
+	if !bytes.Contains(PngCTDepthMap[p.colorType], []byte{p.depth}) {
+		return fmt.Errorf("%w: invalid bit depth %d for color type %d", ErrFormat, p.depth, p.colorType)
+	}
 	p.compression = data[10]
-	if p.compression != 0 { return errors.New("invalid compression value") }
+	if p.compression != 0 { return fmt.Errorf("%w: invalid compression value", ErrFormat) }
 	p.filter = data[11]
+	if p.filter != 0 { return fmt.Errorf("%w: invalid filter method", ErrFormat) }
 	p.interlace = data[12]
+	if p.interlace > 1 { return fmt.Errorf("%w: invalid interlace method", ErrFormat) }
+	if int(p.w) > PngMaxDataSize || int(p.h) > PngMaxDataSize || p.rawSize() > PngMaxDataSize {
+		return fmt.Errorf("%w: %dx%d", ErrTooLarge, p.w, p.h)
+	}
 	return nil
 }
 
-func (p* PngImage) deconstructIDATData(data []byte, toParse int) error {
-	if len(data) < toParse {
-		return errors.New("not enough length")
+func (p* PngImage) deconstructPLTEData(data []byte) error {
+	if len(data) == 0 || len(data) % 3 != 0 || len(data) > 256 * 3 {
+		return fmt.Errorf("%w: invalid palette length", ErrFormat)
 	}
+	p.Palette = append([]byte{}, data...)
+	return nil
+}
+
+// reverses filters of a w by h image stored as consecutive filtered scanlines,
+// returns the unfiltered rows and the count of bytes consumed
+func (p PngImage) unfilterImage(data []byte, w, h int) ([]byte, int, error) {
+	rowSize := (w * p.BitsPerPixel() + 7) / 8
+	size := (rowSize + 1) * h
+	if len(data) < size {
+		return nil, 0, fmt.Errorf("%w: not enough image data", ErrFormat)
+	}
+	bpp := p.filterOffset()
+	output := make([]byte, rowSize * h)
+	prev := make([]byte, rowSize)
+	for y := range(h) {
+		filter := data[y * (rowSize + 1)]
+		row := output[y * rowSize:(y + 1) * rowSize]
+		copy(row, data[y * (rowSize + 1) + 1:(y + 1) * (rowSize + 1)])
+		err := unfilterRow(row, prev, filter, bpp)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: row %d: %s", ErrFormat, y, err)
+		}
+		prev = row
+	}
+	return output, size, nil
+}
 
-	w := p.actualWidth()
+type adam7Pass struct {
+	x, y, dx, dy int
+}
 
-	decompressed := DecompressZlib(data)
+var adam7Passes = [7]adam7Pass{
+	{0, 0, 8, 8},
+	{4, 0, 8, 8},
+	{0, 4, 4, 8},
+	{2, 0, 4, 4},
+	{0, 2, 2, 4},
+	{1, 0, 2, 2},
+	{0, 1, 1, 2},
+}
 
-	p.ImageData = nil
-	p.ImageData = []byte{}
+func copyPixel(dst []byte, dstIdx int, src []byte, srcIdx int, bits int) {
+	if bits >= 8 {
+		n := bits / 8
+		copy(dst[dstIdx * n:(dstIdx + 1) * n], src[srcIdx * n:(srcIdx + 1) * n])
+		return
+	}
+	srcBit := srcIdx * bits
+	dstBit := dstIdx * bits
+	v := (src[srcBit / 8] >> (8 - bits - srcBit % 8)) & byte(1 << bits - 1)
+	dst[dstBit / 8] |= v << (8 - bits - dstBit % 8)
+}
 
-	var index int
-	for i := range(int(p.h)) {
-		// filter := decompressed[i * w]
-		for j := 1; j < w; j++ {
-			index = i * w + j
-			if decompressed[index] == 255 {
-				return nil
+func (p* PngImage) deinterlace(data []byte) error {
+	w := int(p.w)
+	h := int(p.h)
+	bits := p.BitsPerPixel()
+	rowSize := p.RowSize()
+	p.ImageData = make([]byte, p.DataSize())
+	offset := 0
+	for _, pass := range(adam7Passes) {
+		pw := (w - pass.x + pass.dx - 1) / pass.dx
+		ph := (h - pass.y + pass.dy - 1) / pass.dy
+		if pw <= 0 || ph <= 0 {
+			continue
+		}
+		rows, n, err := p.unfilterImage(data[offset:], pw, ph)
+		if err != nil {
+			return err
+		}
+		offset += n
+		passRowSize := (pw * bits + 7) / 8
+		for j := range(ph) {
+			dstRow := p.ImageData[(pass.y + j * pass.dy) * rowSize:]
+			srcRow := rows[j * passRowSize:]
+			for i := range(pw) {
+				copyPixel(dstRow, pass.x + i * pass.dx, srcRow, i, bits)
 			}
-		  p.ImageData = append(p.ImageData, decompressed[index])
 		}
 	}
-
 	return nil
 }
 
-func (p* PngImage) From(data []byte) (int, error) {
+func (p* PngImage) decodeImageData() error {
+	if len(p.compressed) == 0 {
+		return fmt.Errorf("%w: no IDAT chunks", ErrFormat)
+	}
+	if p.colorType == PngCT_Palette && len(p.Palette) == 0 {
+		return fmt.Errorf("%w: no PLTE chunk for palette image", ErrFormat)
+	}
+	data, err := DecompressZlib(p.compressed, p.rawSize())
+	p.compressed = nil
+	if err != nil {
+		return err
+	}
+	// checked before deinterlace allocates the whole image
+	if len(data) < p.rawSize() {
+		return fmt.Errorf("%w: not enough image data", ErrFormat)
+	}
+	if p.interlace == 1 {
+		return p.deinterlace(data)
+	}
+	p.ImageData, _, err = p.unfilterImage(data, int(p.w), int(p.h))
+	return err
+}
+
+// reads chunks up to and including the first one of type stopAt
+func (p* PngImage) readChunks(data []byte, stopAt string) (int, error) {
 	err := deconstructSignature(data)
 	if err != nil {
 		return 0, err
@@ -279,25 +484,39 @@ func (p* PngImage) From(data []byte) (int, error) {
 
 	var offset int
 	globalOffset := PngSignatureLength
+	p.Palette = nil
+	p.Transparency = nil
+	p.compressed = nil
 
 	typ := PngIHDR
 	offset, err = p.deconstructChunk(data[globalOffset:], &typ)
-	globalOffset += offset
 	if err != nil {
-		return offset, err
+		return globalOffset + offset, ChunkError{ Type: typ, Offset: globalOffset, Err: err }
 	}
+	globalOffset += offset
 
-	for typ != PngIEND && globalOffset < len(data) {
+	for typ != stopAt && typ != PngIEND {
+		if globalOffset >= len(data) {
+			return globalOffset, fmt.Errorf("%w: missing IEND chunk", ErrTruncatedChunk)
+		}
 		typ = ""
 		offset, err = p.deconstructChunk(data[globalOffset:], &typ)
-		globalOffset += offset
 		if err != nil {
-			return globalOffset, err
+			return globalOffset + offset, ChunkError{ Type: typ, Offset: globalOffset, Err: err }
 		}
+		globalOffset += offset
 	}
 	return globalOffset, nil
 }
 
+func (p* PngImage) From(data []byte) (int, error) {
+	offset, err := p.readChunks(data, PngIEND)
+	if err != nil {
+		return offset, err
+	}
+	return offset, p.decodeImageData()
+}
+
 func (p* PngImage) Default(w, h uint32, imageData []byte) {
 	p.w = w
 	p.h = h
@@ -307,6 +526,59 @@ func (p* PngImage) Default(w, h uint32, imageData []byte) {
 	p.compression = 0
 	p.filter = 0
 	p.interlace = 0
+	p.level = zlib.DefaultCompression
+	p.rowFilter = PngFilter_Adaptive
+	p.idatSize = PngDefaultIDATSize
+}
+
+func (p* PngImage) Configure(w, h uint32, colorType, depth byte) error {
+	if w == 0 || h == 0 {
+		return errors.New("invalid dimensions")
+	}
+	if int(colorType) >= len(PngCTSizeMap) || PngCTSizeMap[colorType] == 0 {
+		return fmt.Errorf("invalid color type %d", colorType)
+	}
+	if !bytes.Contains(PngCTDepthMap[colorType], []byte{depth}) {
+		return fmt.Errorf("invalid bit depth %d for color type %d", depth, colorType)
+	}
+	p.Default(w, h, p.ImageData)
+	p.colorType = colorType
+	p.depth = depth
+	return nil
+}
+
+func (p* PngImage) SetPalette(palette []byte, transparency []byte) error {
+	if len(palette) == 0 || len(palette) % 3 != 0 || len(palette) > 256 * 3 {
+		return errors.New("palette must hold 1 to 256 RGB entries")
+	}
+	if len(transparency) > len(palette) / 3 {
+		return errors.New("more transparency entries than palette entries")
+	}
+	p.Palette = palette
+	p.Transparency = transparency
+	return nil
+}
+
+func (p* PngImage) SetCompressionLevel(level int) error {
+	if level < zlib.HuffmanOnly || level > zlib.BestCompression {
+		return fmt.Errorf("invalid compression level %d", level)
+	}
+	p.level = level
+	return nil
+}
+
+// filter is either one of PngFilter_* or PngFilter_Adaptive
+func (p* PngImage) SetRowFilter(filter int) error {
+	if filter != PngFilter_Adaptive && (filter < 0 || filter >= pngFilterCount) {
+		return fmt.Errorf("invalid filter %d", filter)
+	}
+	p.rowFilter = filter
+	return nil
+}
+
+// size <= 0 writes all image data into a single IDAT chunk
+func (p* PngImage) SetIDATSize(size int) {
+	p.idatSize = size
 }
 
 func DisplayByteArr(data []byte) {
@@ -316,9 +588,6 @@ func DisplayByteArr(data []byte) {
 	fmt.Println()
 }
 
-// TODO
-// minify image where w*h > len(imageData) when constructing
-// 
 func main() {
 	if len(os.Args) < 4 {
 		fmt.Println("oops: not enough arguments. Usage: [program] [encode|decode] [filename1] [filename2]")
@@ -0,0 +1,328 @@
+package png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	stdpng "image/png"
+	"math/rand"
+	"testing"
+)
+
+// a color type and bit depth pair, with or without Adam7 interlacing
+type rawFormat struct {
+	colorType byte
+	depth byte
+	interlace byte
+}
+
+func (f rawFormat) String() string {
+	return fmt.Sprintf("ct%d-d%d-i%d", f.colorType, f.depth, f.interlace)
+}
+
+func allFormats() []rawFormat {
+	var formats []rawFormat
+	for _, ct := range([]byte{ PngCT_Grayscale, PngCT_RGB, PngCT_Palette, PngCT_GrayscaleAlpha, PngCT_RGB_Alpha }) {
+		for _, depth := range(PngCTDepthMap[ct]) {
+			for _, interlace := range([]byte{ 0, 1 }) {
+				formats = append(formats, rawFormat{ ct, depth, interlace })
+			}
+		}
+	}
+	return formats
+}
+
+// writes a PNG the way any encoder may, independent of PngImage.Encode:
+// random samples, filter None, optionally interlaced and split into small IDATs
+func rawPng(r *rand.Rand, f rawFormat, w, h int, withTRNS bool) []byte {
+	bits := PngCTSizeMap[f.colorType] * int(f.depth)
+	paletteSize := 1 << min(f.depth, 8)
+	sampleMax := 1 << f.depth - 1
+	if f.colorType == PngCT_Palette {
+		sampleMax = paletteSize - 1
+	}
+	// samples of every pixel, later packed per pass
+	samples := make([][]int, w * h)
+	for i := range(samples) {
+		samples[i] = make([]int, PngCTSizeMap[f.colorType])
+		for c := range(samples[i]) {
+			samples[i][c] = r.Intn(sampleMax + 1)
+		}
+	}
+	packRows := func(xs, ys, dx, dy int) []byte {
+		pw := (w - xs + dx - 1) / dx
+		ph := (h - ys + dy - 1) / dy
+		if pw <= 0 || ph <= 0 {
+			return nil
+		}
+		rowSize := (pw * bits + 7) / 8
+		var out []byte
+		for j := range(ph) {
+			row := make([]byte, rowSize)
+			bit := 0
+			for i := range(pw) {
+				for _, v := range(samples[(ys + j * dy) * w + xs + i * dx]) {
+					if f.depth == 16 {
+						binary.BigEndian.PutUint16(row[bit / 8:], uint16(v))
+					} else {
+						row[bit / 8] |= byte(v) << (8 - int(f.depth) - bit % 8)
+					}
+					bit += int(f.depth)
+				}
+			}
+			out = append(out, 0)
+			out = append(out, row...)
+		}
+		return out
+	}
+	var raw []byte
+	if f.interlace == 0 {
+		raw = packRows(0, 0, 1, 1)
+	} else {
+		for _, pass := range(adam7Passes) {
+			raw = append(raw, packRows(pass.x, pass.y, pass.dx, pass.dy)...)
+		}
+	}
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(raw)
+	zw.Close()
+
+	out := append([]byte{}, PngSignature...)
+	ihdr := binary.BigEndian.AppendUint32(nil, uint32(w))
+	ihdr = binary.BigEndian.AppendUint32(ihdr, uint32(h))
+	ihdr = append(ihdr, f.depth, f.colorType, 0, 0, f.interlace)
+	out = append(out, ConstructPngChunk(ihdr, PngIHDR, uint32(len(ihdr)))...)
+	if f.colorType == PngCT_Palette {
+		palette := make([]byte, paletteSize * 3)
+		r.Read(palette)
+		out = append(out, ConstructPngChunk(palette, PngPLTE, uint32(len(palette)))...)
+		if withTRNS {
+			trns := make([]byte, paletteSize / 2 + 1)
+			r.Read(trns)
+			out = append(out, ConstructPngChunk(trns, PngtRNS, uint32(len(trns)))...)
+		}
+	} else if withTRNS && (f.colorType == PngCT_Grayscale || f.colorType == PngCT_RGB) {
+		// key on the first pixel so that at least one pixel is transparent
+		var trns []byte
+		for _, v := range(samples[0]) {
+			trns = binary.BigEndian.AppendUint16(trns, uint16(v))
+		}
+		out = append(out, ConstructPngChunk(trns, PngtRNS, uint32(len(trns)))...)
+	}
+	data := z.Bytes()
+	for len(data) > 0 {
+		n := min(len(data), 7)
+		out = append(out, ConstructPngChunk(data[:n], PngIDAT, uint32(n))...)
+		data = data[n:]
+	}
+	return append(out, ConstructPngChunk(nil, PngIEND, 0)...)
+}
+
+// images the standard library encoder writes, covering what it can produce:
+// 8 and 16 bit gray, RGB and RGBA, and palettes of 1, 2, 4 and 8 bits
+func stdlibImages(r *rand.Rand) []image.Image {
+	rect := image.Rect(0, 0, 13, 7)
+	gray := image.NewGray(rect)
+	gray16 := image.NewGray16(rect)
+	nrgba := image.NewNRGBA(rect)
+	nrgba64 := image.NewNRGBA64(rect)
+	opaque := image.NewNRGBA(rect)
+	opaque64 := image.NewNRGBA64(rect)
+	r.Read(gray.Pix)
+	r.Read(gray16.Pix)
+	r.Read(nrgba.Pix)
+	r.Read(nrgba64.Pix)
+	r.Read(opaque.Pix)
+	r.Read(opaque64.Pix)
+	for i := 3; i < len(opaque.Pix); i += 4 {
+		opaque.Pix[i] = 0xFF
+	}
+	for i := 6; i < len(opaque64.Pix); i += 8 {
+		opaque64.Pix[i], opaque64.Pix[i + 1] = 0xFF, 0xFF
+	}
+	images := []image.Image{ gray, gray16, nrgba, nrgba64, opaque, opaque64 }
+	for _, n := range([]int{ 2, 4, 16, 256 }) {
+		palette := make(color.Palette, n)
+		for i := range(palette) {
+			palette[i] = color.NRGBA{ byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)) }
+		}
+		paletted := image.NewPaletted(rect, palette)
+		for i := range(paletted.Pix) {
+			paletted.Pix[i] = byte(r.Intn(n))
+		}
+		images = append(images, paletted)
+	}
+	return images
+}
+
+func stdlibEncode(t testing.TB, img image.Image) []byte {
+	var b bytes.Buffer
+	if err := stdpng.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func corpus(t testing.TB) [][]byte {
+	r := rand.New(rand.NewSource(1))
+	var seeds [][]byte
+	for _, img := range(stdlibImages(r)) {
+		seeds = append(seeds, stdlibEncode(t, img))
+	}
+	for _, f := range(allFormats()) {
+		seeds = append(seeds, rawPng(r, f, 9, 5, false), rawPng(r, f, 3, 10, true))
+	}
+	return seeds
+}
+
+func sameImage(a, b image.Image) error {
+	if a.Bounds() != b.Bounds() {
+		return fmt.Errorf("bounds %v and %v", a.Bounds(), b.Bounds())
+	}
+	for y := a.Bounds().Min.Y; y < a.Bounds().Max.Y; y++ {
+		for x := a.Bounds().Min.X; x < a.Bounds().Max.X; x++ {
+			ca := color.NRGBA64Model.Convert(a.At(x, y)).(color.NRGBA64)
+			cb := color.NRGBA64Model.Convert(b.At(x, y)).(color.NRGBA64)
+			if ca.A == 0 && cb.A == 0 {
+				continue
+			}
+			if ca != cb {
+				return fmt.Errorf("pixel %d,%d is %v, want %v", x, y, ca, cb)
+			}
+		}
+	}
+	return nil
+}
+
+// every color type, depth and interlace mode decodes to what image/png decodes
+func TestDecodeMatchesStdlib(t *testing.T) {
+	for _, data := range(corpus(t)) {
+		want, err := stdpng.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%T: %s", want, err)
+		}
+		if err = sameImage(got, want); err != nil {
+			t.Errorf("%T: %s", want, err)
+		}
+		config, err := DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Width != want.Bounds().Dx() || config.Height != want.Bounds().Dy() {
+			t.Errorf("config is %dx%d, want %v", config.Width, config.Height, want.Bounds())
+		}
+	}
+}
+
+// images encoded here decode with image/png to the image they were made from
+func TestEncodeMatchesStdlib(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for _, img := range(stdlibImages(r)) {
+		var b bytes.Buffer
+		if err := Encode(&b, img); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := stdpng.Decode(&b)
+		if err != nil {
+			t.Fatalf("%T: %s", img, err)
+		}
+		if err = sameImage(decoded, img); err != nil {
+			t.Errorf("%T: %s", img, err)
+		}
+	}
+}
+
+// stdlib output survives From, Image, FromImage and Encode unchanged
+func TestRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for _, img := range(stdlibImages(r)) {
+		var p PngImage
+		if _, err := p.From(stdlibEncode(t, img)); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := p.Image()
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := FromImage(decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := stdpng.Decode(bytes.NewReader(q.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = sameImage(again, img); err != nil {
+			t.Errorf("%T: %s", img, err)
+		}
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	valid := stdlibEncode(t, image.NewGray(image.Rect(0, 0, 4, 4)))
+	badCRC := append([]byte{}, valid...)
+	badCRC[PngSignatureLength + 20] ^= 1
+	badZlib := rawPng(rand.New(rand.NewSource(4)), rawFormat{ PngCT_Grayscale, 8, 0 }, 4, 4, false)
+	// first IDAT holds the zlib header
+	idat := bytes.Index(badZlib, []byte(PngIDAT))
+	badZlib[idat + 4] ^= 0xFF
+	binary.BigEndian.PutUint32(badZlib[idat + 4 + 7:], ComputeCRC(PngCRCInitVal, badZlib[idat:idat + 4 + 7]))
+	cases := []struct {
+		data []byte
+		want error
+	}{
+		{ []byte("not a png"), ErrBadSignature },
+		{ valid[:PngSignatureLength + 10], ErrTruncatedChunk },
+		{ badCRC, ErrBadCRC },
+		{ badZlib, ErrBadZlib },
+	}
+	for _, c := range(cases) {
+		_, err := Decode(bytes.NewReader(c.data))
+		if !errors.Is(err, c.want) {
+			t.Errorf("got %v, want %v", err, c.want)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, seed := range(corpus(f)) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		img, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		config, err := DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Decode succeeded but DecodeConfig failed: %s", err)
+		}
+		if config.Width != img.Bounds().Dx() || config.Height != img.Bounds().Dy() {
+			t.Fatalf("config is %dx%d, image is %v", config.Width, config.Height, img.Bounds())
+		}
+	})
+}
+
+func FuzzDecodeConfig(f *testing.F) {
+	for _, seed := range(corpus(f)) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		config, err := DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if config.Width <= 0 || config.Height <= 0 {
+			t.Fatalf("config is %dx%d", config.Width, config.Height)
+		}
+	})
+}
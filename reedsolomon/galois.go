@@ -0,0 +1,133 @@
+package reedsolomon
+
+// arithmetic in GF(2^8) with the polynomial x^8 + x^4 + x^3 + x^2 + 1
+
+const gfPolynomial = 0x11D
+
+var expTable [510]byte
+var logTable [256]byte
+var mulTable [256][256]byte
+
+func init() {
+	x := 1
+	for i := range(255) {
+		expTable[i] = byte(x)
+		expTable[i + 255] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x & 0x100 != 0 {
+			x ^= gfPolynomial
+		}
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			mulTable[a][b] = expTable[int(logTable[a]) + int(logTable[b])]
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	return mulTable[a][b]
+}
+
+// a must not be 0
+func gfInv(a byte) byte {
+	return expTable[255 - int(logTable[a])]
+}
+
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a]) * n % 255]
+}
+
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for r := range(m) {
+		m[r] = make([]byte, cols)
+	}
+	return m
+}
+
+// any square selection of its rows is invertible
+func vandermonde(rows, cols int) matrix {
+	m := newMatrix(rows, cols)
+	for r := range(rows) {
+		for c := range(cols) {
+			m[r][c] = gfPow(byte(r), c)
+		}
+	}
+	return m
+}
+
+func (m matrix) multiply(o matrix) matrix {
+	out := newMatrix(len(m), len(o[0]))
+	for r := range(m) {
+		for c := range(o[0]) {
+			var v byte
+			for i := range(o) {
+				v ^= gfMul(m[r][i], o[i][c])
+			}
+			out[r][c] = v
+		}
+	}
+	return out
+}
+
+// Gauss-Jordan elimination on a copy of a square matrix
+func (m matrix) invert() (matrix, error) {
+	n := len(m)
+	work := newMatrix(n, n * 2)
+	for r := range(n) {
+		copy(work[r], m[r])
+		work[r][n + r] = 1
+	}
+	for c := range(n) {
+		pivot := -1
+		for r := c; r < n; r++ {
+			if work[r][c] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, ErrSingular
+		}
+		work[c], work[pivot] = work[pivot], work[c]
+		scale := gfInv(work[c][c])
+		for i := range(work[c]) {
+			work[c][i] = gfMul(work[c][i], scale)
+		}
+		for r := range(n) {
+			if r == c || work[r][c] == 0 {
+				continue
+			}
+			f := work[r][c]
+			for i := range(work[r]) {
+				work[r][i] ^= gfMul(f, work[c][i])
+			}
+		}
+	}
+	out := newMatrix(n, n)
+	for r := range(n) {
+		copy(out[r], work[r][n:])
+	}
+	return out, nil
+}
+
+// dst ^= coef * src
+func mulAdd(dst, src []byte, coef byte) {
+	if coef == 0 {
+		return
+	}
+	row := &mulTable[coef]
+	for i, b := range(src) {
+		dst[i] ^= row[b]
+	}
+}
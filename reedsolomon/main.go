@@ -0,0 +1,145 @@
+package reedsolomon
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidShardCount = errors.New("invalid shard count")
+	ErrShardSize = errors.New("shards differ in size")
+	ErrTooFewShards = errors.New("too few shards to reconstruct")
+	ErrSingular = errors.New("matrix is singular")
+)
+
+// systematic erasure code: data shards are stored as they are, and any
+// parity of them may be lost and still be recovered from the rest
+type Code struct {
+	data int
+	parity int
+	// identity on top, parity rows below
+	matrix matrix
+}
+
+func New(data, parity int) (*Code, error) {
+	if data < 1 || parity < 0 || data + parity > 256 {
+		return nil, fmt.Errorf("%w: %d data and %d parity", ErrInvalidShardCount, data, parity)
+	}
+	v := vandermonde(data + parity, data)
+	top, err := v[:data].invert()
+	if err != nil {
+		return nil, err
+	}
+	return &Code{ data: data, parity: parity, matrix: v.multiply(top) }, nil
+}
+
+func (c *Code) DataShards() int {
+	return c.data
+}
+
+func (c *Code) ParityShards() int {
+	return c.parity
+}
+
+func (c *Code) checkCount(shards [][]byte) error {
+	if len(shards) != c.data + c.parity {
+		return fmt.Errorf("%w: got %d, want %d", ErrInvalidShardCount, len(shards), c.data + c.parity)
+	}
+	return nil
+}
+
+// size of the shards that are present
+func shardSize(shards [][]byte) (int, error) {
+	size := -1
+	for _, s := range(shards) {
+		if s == nil {
+			continue
+		}
+		if size < 0 {
+			size = len(s)
+		} else if len(s) != size {
+			return 0, ErrShardSize
+		}
+	}
+	return size, nil
+}
+
+// fills the parity shards from the data shards, which have to be the same size
+func (c *Code) Encode(shards [][]byte) error {
+	if err := c.checkCount(shards); err != nil {
+		return err
+	}
+	size, err := shardSize(shards[:c.data])
+	if err != nil {
+		return err
+	}
+	for i := range(c.data) {
+		if shards[i] == nil {
+			return fmt.Errorf("%w: data shard %d is missing", ErrTooFewShards, i)
+		}
+	}
+	for p := range(c.parity) {
+		out := make([]byte, size)
+		for i := range(c.data) {
+			mulAdd(out, shards[i], c.matrix[c.data + p][i])
+		}
+		shards[c.data + p] = out
+	}
+	return nil
+}
+
+// fills in nil shards, as long as at least as many shards as there are data ones are left
+func (c *Code) Reconstruct(shards [][]byte) error {
+	if err := c.checkCount(shards); err != nil {
+		return err
+	}
+	size, err := shardSize(shards)
+	if err != nil {
+		return err
+	}
+	var rows []int
+	missingData := false
+	for i, s := range(shards) {
+		if s != nil {
+			if len(rows) < c.data {
+				rows = append(rows, i)
+			}
+		} else if i < c.data {
+			missingData = true
+		}
+	}
+	if len(rows) < c.data {
+		return fmt.Errorf("%w: %d of %d needed", ErrTooFewShards, len(rows), c.data)
+	}
+	if missingData {
+		sub := newMatrix(c.data, c.data)
+		for i, r := range(rows) {
+			copy(sub[i], c.matrix[r])
+		}
+		dec, err := sub.invert()
+		if err != nil {
+			return err
+		}
+		for i := range(c.data) {
+			if shards[i] != nil {
+				continue
+			}
+			out := make([]byte, size)
+			for j, r := range(rows) {
+				mulAdd(out, shards[r], dec[i][j])
+			}
+			shards[i] = out
+		}
+	}
+	for p := range(c.parity) {
+		if shards[c.data + p] != nil {
+			continue
+		}
+		out := make([]byte, size)
+		for i := range(c.data) {
+			mulAdd(out, shards[i], c.matrix[c.data + p][i])
+		}
+		shards[c.data + p] = out
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package reedsolomon
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestReconstruct(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for range(300) {
+		data := 1 + r.Intn(20)
+		parity := r.Intn(8)
+		c, err := New(data, parity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards := make([][]byte, data + parity)
+		size := r.Intn(100)
+		for i := range(data) {
+			shards[i] = make([]byte, size)
+			r.Read(shards[i])
+		}
+		if err = c.Encode(shards); err != nil {
+			t.Fatal(err)
+		}
+		want := make([][]byte, len(shards))
+		for i := range(shards) {
+			want[i] = append([]byte{}, shards[i]...)
+		}
+		// any parity shards may be lost
+		lost := r.Perm(data + parity)[:r.Intn(parity + 1)]
+		for _, i := range(lost) {
+			shards[i] = nil
+		}
+		if err = c.Reconstruct(shards); err != nil {
+			t.Fatalf("%d+%d losing %v: %s", data, parity, lost, err)
+		}
+		for i := range(shards) {
+			if !bytes.Equal(shards[i], want[i]) {
+				t.Fatalf("%d+%d losing %v: shard %d differs", data, parity, lost, i)
+			}
+		}
+		// but not more
+		if parity > 0 {
+			for _, i := range(r.Perm(data + parity)[:parity + 1]) {
+				shards[i] = nil
+			}
+			if err = c.Reconstruct(shards); !errors.Is(err, ErrTooFewShards) {
+				t.Fatalf("%d+%d: got %v, want %v", data, parity, err, ErrTooFewShards)
+			}
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(200, 56); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range([][2]int{ { 0, 1 }, { 1, -1 }, { 200, 57 } }) {
+		if _, err := New(c[0], c[1]); !errors.Is(err, ErrInvalidShardCount) {
+			t.Errorf("%d+%d: got %v, want %v", c[0], c[1], err, ErrInvalidShardCount)
+		}
+	}
+}
+
+func TestShardSize(t *testing.T) {
+	c, _ := New(2, 1)
+	if err := c.Encode([][]byte{ { 1, 2 }, { 3 }, nil }); !errors.Is(err, ErrShardSize) {
+		t.Errorf("got %v, want %v", err, ErrShardSize)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// keeps r in step with the hub: files put into or removed from the hub are put
+// into or removed from r as well, and files the hub fails to read are read from r
+func (sh* StickerHub) WithReplica(r *StickerHub) {
+	sh.replicas = append(sh.replicas, r)
+}
+
+// marks the hub as a replica standing in for the unavailable one named hub.
+// replicas only follow the hub, so one standing in is read-only: files put
+// into it would be removed once the hub is back and syncs the replicas
+func (sh* StickerHub) StandInFor(hub string) {
+	sh.standInFor = hub
+}
+
+func (sh StickerHub) checkWritable() error {
+	if sh.standInFor != "" {
+		return fmt.Errorf("hub \"%s\" is unavailable and replica \"%s\" is read-only", sh.standInFor, sh.Name())
+	}
+	return nil
+}
+
+// the open replica with the given first set
+func (sh StickerHub) Replica(name string) (*StickerHub, bool) {
+	for _, r := range(sh.replicas) {
+		if r.Name() == name {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// entries of different hubs are the same file if their ids match,
+// entries written before ids are compared by name and size
+func sameFile(a StickerHubInfoEntry, b StickerHubInfoEntry) bool {
+	if a.Id != "" && b.Id != "" {
+		return a.Id == b.Id
+	}
+	return a.Filename == b.Filename && a.Size == b.Size
+}
+
+func (sh StickerHub) findEntry(e StickerHubInfoEntry) (int, bool) {
+	for i, o := range(sh.info) {
+		if sameFile(o, e) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (sh* StickerHub) ReadFile(idx int) ([]byte, error) {
+	data, err := sh.readFile(idx)
+	if err == nil {
+		return data, nil
+	}
+	for _, r := range(sh.replicas) {
+		j, ok := r.findEntry(sh.info[idx])
+		if !ok {
+			continue
+		}
+		data, rerr := r.readFile(j)
+		if rerr == nil {
+			fmt.Fprintf(os.Stderr, "%s, read it from replica \"%s\"\n", err, r.Name())
+			return data, nil
+		}
+	}
+	return nil, err
+}
+
+func (sh* StickerHub) UploadData(filename string, fileData []byte) error {
+	if err := sh.checkWritable(); err != nil {
+		return err
+	}
+	id := generateFileId()
+	if err := sh.uploadData(filename, fileData, id, nil); err != nil {
+		return err
+	}
+	// carrier, pool and parity given for this put hold for the copies too
+	layout := sh.layout()
+	for _, r := range(sh.replicas) {
+		fmt.Printf("Writing \"%s\" to replica \"%s\"\n", filename, r.Name())
+		saved := r.layout()
+		r.withLayout(layout)
+		err := r.uploadData(filename, fileData, id, nil)
+		r.withLayout(saved)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Replica \"%s\": %s, run replica sync to copy the file there\n", r.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (sh* StickerHub) RemoveFile(idx int) error {
+	if err := sh.checkWritable(); err != nil {
+		return err
+	}
+	e := sh.info[idx]
+	if err := sh.removeFile(idx); err != nil {
+		return err
+	}
+	for _, r := range(sh.replicas) {
+		j, ok := r.findEntry(e)
+		if !ok {
+			continue
+		}
+		if err := r.removeFile(j); err != nil {
+			fmt.Fprintf(os.Stderr, "Replica \"%s\": %s, run replica sync to remove the file there\n", r.Name(), err)
+		}
+	}
+	return nil
+}
+
+// how new files are written to a hub
+type uploadLayout struct {
+	carrier Carrier
+	pool string
+	group int
+	parity int
+}
+
+func (sh StickerHub) layout() uploadLayout {
+	return uploadLayout{ sh.carrier, sh.pool, sh.parityGroup, sh.parity }
+}
+
+func (sh* StickerHub) withLayout(l uploadLayout) {
+	sh.carrier, sh.pool, sh.parityGroup, sh.parity = l.carrier, l.pool, l.group, l.parity
+}
+
+// layout the file of e was written to the hub with, falling back to the hub's
+// own carrier when the file's one cannot encode without a cover
+func (sh StickerHub) entryLayout(e StickerHubInfoEntry) uploadLayout {
+	l := uploadLayout{ pool: sh.filePool(e), group: e.Group, parity: e.Parity }
+	if l.group == 0 {
+		l.group = DefaultParityGroup
+	}
+	c, err := sh.entryCarrier(e.Carrier)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\"%s\": %s, copying it with carrier \"%s\"\n", e.Filename, err, sh.uploadCarrier().Id())
+		c = sh.uploadCarrier()
+	}
+	l.carrier = c
+	return l
+}
+
+// makes the replicas hold what the hub holds: files the hub lacks are removed
+// from them, and files some of them lack or fail to read are copied from one
+// that reads them, the hub itself included
+func (sh* StickerHub) SyncReplicas() error {
+	if err := sh.checkWritable(); err != nil {
+		return err
+	}
+	repaired, removed, lost := 0, 0, 0
+	for _, r := range(sh.replicas) {
+		for j := len(r.info) - 1; j >= 0; j-- {
+			if _, ok := sh.findEntry(r.info[j]); ok {
+				continue
+			}
+			name := r.info[j].Filename
+			if err := r.removeFile(j); err != nil {
+				return fmt.Errorf("remove \"%s\" from \"%s\": %s", name, r.Name(), err)
+			}
+			fmt.Printf("Removed \"%s\" from \"%s\"\n", name, r.Name())
+			removed++
+		}
+	}
+	hubs := append([]*StickerHub{ sh }, sh.replicas...)
+	// rewriting keeps entries of the hub in place
+	for _, e := range(sh.info) {
+		var data []byte
+		found := false
+		var stale []*StickerHub
+		for _, h := range(hubs) {
+			j, ok := h.findEntry(e)
+			if !ok {
+				stale = append(stale, h)
+				continue
+			}
+			d, err := h.readFile(j)
+			if err != nil {
+				fmt.Printf("\"%s\" is unreadable in \"%s\": %s\n", e.Filename, h.Name(), err)
+				stale = append(stale, h)
+				continue
+			}
+			if !found {
+				data, found = d, true
+			}
+		}
+		if len(stale) == 0 {
+			continue
+		}
+		if !found {
+			fmt.Printf("\"%s\" cannot be read from any replica\n", e.Filename)
+			lost++
+			continue
+		}
+		layout := sh.entryLayout(e)
+		for _, h := range(stale) {
+			if err := h.rewriteFile(e, data, layout); err != nil {
+				return fmt.Errorf("copy \"%s\" to \"%s\": %s", e.Filename, h.Name(), err)
+			}
+			fmt.Printf("Copied \"%s\" to \"%s\"\n", e.Filename, h.Name())
+			repaired++
+		}
+	}
+	fmt.Printf("Synced %d replicas of \"%s\": %d copies repaired, %d removed, %d files lost\n", len(sh.replicas), sh.Title(), repaired, removed, lost)
+	return nil
+}
+
+// puts data into the hub as the file of e with the given layout, in place of the
+// hub's own copy of it. none of the stickers of that copy are reused, as it may
+// be the damaged one
+func (sh* StickerHub) rewriteFile(e StickerHubInfoEntry, data []byte, layout uploadLayout) error {
+	saved := sh.layout()
+	sh.withLayout(layout)
+	defer sh.withLayout(saved)
+	j, ok := sh.findEntry(e)
+	if !ok {
+		return sh.uploadData(e.Filename, data, e.Id, nil)
+	}
+	old := sh.info[j]
+	stale := map[string]bool{}
+	for _, id := range(old.Stickers) {
+		stale[id] = true
+	}
+	sh.info = append(sh.info[:j:j], sh.info[j + 1:]...)
+	if err := sh.uploadData(e.Filename, data, e.Id, stale); err != nil {
+		sh.info = append(sh.info[:j:j], append(StickerHubInfo{ old }, sh.info[j:]...)...)
+		return err
+	}
+	last := len(sh.info) - 1
+	sh.info = append(sh.info[:j:j], append(StickerHubInfo{ sh.info[last] }, sh.info[j:last]...)...)
+	// removing the old copy deletes the stickers no file holds chunks in any more
+	sh.info = append(sh.info, old)
+	return sh.removeFile(len(sh.info) - 1)
+}
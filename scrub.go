@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sergeykochiev/tgsh/telegram"
+)
+
+type scrubReport struct {
+	files int
+	stickers int
+	damaged int
+	repaired int
+	lost int
+}
+
+// set and position in it of a sticker of the chain
+func (sh StickerHub) stickerPosition(uniqueId string) (int, int, bool) {
+	for k, set := range(sh.sets) {
+		for i, s := range(set.Stickers) {
+			if s.UniqueId == uniqueId {
+				return k, i, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// type of the sets the stickers of a file are in
+func (sh StickerHub) filePool(e StickerHubInfoEntry) string {
+	for _, id := range(e.Stickers) {
+		if k, _, ok := sh.stickerPosition(id); ok {
+			return setType(sh.sets[k])
+		}
+	}
+	return sh.uploadPool()
+}
+
+// type of the sets the i-th sticker of a file was made for. chunks shared with
+// other files may be in sets of another type than the rest of the file
+func (sh StickerHub) stickerPool(e StickerHubInfoEntry, i int) string {
+	if k, _, ok := sh.stickerPosition(e.Stickers[i]); ok {
+		return setType(sh.sets[k])
+	}
+	if j := e.dataIndex(i); j >= 0 {
+		if record, ok := sh.chunks[chunkKey(e.Carrier, e.Hashes[j])]; ok && record.Pool != "" {
+			return record.Pool
+		}
+	}
+	return sh.filePool(e)
+}
+
+// carrier to rewrite stickers of an entry with. the configured one is preferred
+// when it matches, as only it has a cover to encode with
+func (sh StickerHub) entryCarrier(id string) (Carrier, error) {
+	c := sh.uploadCarrier()
+	if c.Id() == id || id == "" && c.Id() == CarrierAlpha {
+		return c, nil
+	}
+	return newCarrier(id, "")
+}
+
+// puts sticker in place of old in its set and returns it as stored by Telegram
+func (sh* StickerHub) replaceSticker(old telegram.Sticker, sticker telegram.InputSticker) (telegram.Sticker, error) {
+	k, pos, ok := sh.stickerPosition(old.UniqueId)
+	if !ok {
+		return telegram.Sticker{}, fmt.Errorf("sticker %s is not in the hub's sets", old.UniqueId)
+	}
+	name := sh.sets[k].Name
+	ok, err := sh.bot.ReplaceStickerInSet(telegram.ParamsReplaceStickerInSet{
+		UserId: sh.userId,
+		Name: name,
+		OldSticker: old.FileId,
+		Sticker: sticker,
+	})
+	if err != nil {
+		return telegram.Sticker{}, fmt.Errorf("replace sticker in set: %s", err)
+	}
+	if !ok {
+		return telegram.Sticker{}, fmt.Errorf("replace sticker in set: returned false")
+	}
+	set, err := sh.bot.GetStickerSet(name)
+	if err != nil {
+		return telegram.Sticker{}, fmt.Errorf("get sticker set: %s", err)
+	}
+	if pos >= len(set.Stickers) {
+		return telegram.Sticker{}, fmt.Errorf("set \"%s\" lost stickers while replacing one", name)
+	}
+	sh.sets[k] = set
+	for i := range(sh.stickers) {
+		if sh.stickers[i].UniqueId == old.UniqueId {
+			sh.stickers[i] = set.Stickers[pos]
+		}
+	}
+	return set.Stickers[pos], nil
+}
+
+// moves the i-th sticker of a file, added back at the end of a set, next to the
+// other stickers of the file in that set. only the header says which stickers make
+// up a file, so this keeps the sets readable by eye and nothing depends on it
+func (sh* StickerHub) placeSticker(e StickerHubInfoEntry, i int, sticker telegram.Sticker) error {
+	k, pos, ok := sh.stickerPosition(sticker.UniqueId)
+	if !ok {
+		return fmt.Errorf("sticker %s is not in the hub's sets", sticker.UniqueId)
+	}
+	target := -1
+	for j := i - 1; j >= 0 && target < 0; j-- {
+		if sk, spos, ok := sh.stickerPosition(e.Stickers[j]); ok && sk == k {
+			target = spos + 1
+		}
+	}
+	for j := i + 1; j < len(e.Stickers) && target < 0; j++ {
+		if sk, spos, ok := sh.stickerPosition(e.Stickers[j]); ok && sk == k {
+			target = spos
+		}
+	}
+	if target < 0 || target == pos {
+		return nil
+	}
+	ok, err := sh.bot.SetStickerPositionInSet(sticker.FileId, target)
+	if err != nil {
+		return fmt.Errorf("set sticker position in set: %s", err)
+	}
+	if !ok {
+		return fmt.Errorf("set sticker position in set: returned false")
+	}
+	set, err := sh.bot.GetStickerSet(sh.sets[k].Name)
+	if err != nil {
+		return fmt.Errorf("get sticker set: %s", err)
+	}
+	sh.sets[k] = set
+	return nil
+}
+
+// rewrites the damaged stickers of a file, taking it from its other stickers,
+// parity included, or from one of sources if those are not enough
+func (sh* StickerHub) repairStickers(idx int, damaged []int, sources []*StickerHub) error {
+	e := &sh.info[idx]
+	if e.ChunkSize == 0 {
+		return fmt.Errorf("it was put before chunk sizes were recorded, put it again to make it repairable")
+	}
+	data, err := sh.readFile(idx)
+	if err != nil {
+		for _, r := range(sources) {
+			j, ok := r.findEntry(*e)
+			if !ok {
+				continue
+			}
+			if data, err = r.readFile(j); err == nil {
+				fmt.Printf("Read \"%s\" from replica \"%s\"\n", e.Filename, r.Name())
+				break
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	payloads, err := filePayloads(*e, data)
+	if err != nil {
+		return err
+	}
+	c, err := sh.entryCarrier(e.Carrier)
+	if err != nil {
+		return err
+	}
+	repaired := map[string]bool{}
+	for _, i := range(damaged) {
+		old := e.Stickers[i]
+		// a chunk repeated within the file is held by one sticker
+		if repaired[old] {
+			continue
+		}
+		if i >= len(payloads) || i < len(e.Checksums) && chunkChecksum(payloads[i]) != e.Checksums[i] {
+			return fmt.Errorf("sticker %d does not match the recovered file", i + 1)
+		}
+		pool := sh.stickerPool(*e, i)
+		w, h := stickerSize(pool)
+		input, err := sh.uploadSticker(*e, i, payloads[i], c, w, h)
+		if err != nil {
+			return err
+		}
+		var sticker telegram.Sticker
+		if s, ok := sh.stickerByUniqueId(old); ok {
+			sticker, err = sh.replaceSticker(s, input)
+		} else {
+			sticker, err = sh.addSticker(input, pool)
+			if err == nil {
+				err = sh.placeSticker(*e, i, sticker)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		// other files may hold the same chunk in it
+		sh.replaceReferences(old, sticker.UniqueId)
+		repaired[sticker.UniqueId] = true
+		fmt.Printf("Repaired sticker %d of \"%s\" in \"%s\"\n", i + 1, e.Filename, sh.Name())
+	}
+	return nil
+}
+
+// downloads and checks every sticker of every file, repairing the damaged ones
+func (sh* StickerHub) scrub(sources []*StickerHub, report *scrubReport) error {
+	changed := false
+	for idx := range(sh.info) {
+		e := sh.info[idx]
+		report.files++
+		c, err := newCarrier(e.Carrier, "")
+		if err != nil {
+			fmt.Printf("\"%s\" in \"%s\": %s\n", e.Filename, sh.Name(), err)
+			report.lost++
+			continue
+		}
+		var damaged []int
+		for i := range(e.Stickers) {
+			report.stickers++
+			if _, err := sh.readChunk(e, i, c); err != nil {
+				fmt.Printf("\"%s\" in \"%s\": sticker %d: %s\n", e.Filename, sh.Name(), i + 1, err)
+				damaged = append(damaged, i)
+			}
+		}
+		if len(damaged) == 0 {
+			continue
+		}
+		report.damaged += len(damaged)
+		if err := sh.repairStickers(idx, damaged, sources); err != nil {
+			fmt.Printf("\"%s\" in \"%s\" cannot be repaired: %s\n", e.Filename, sh.Name(), err)
+			report.lost++
+			// stickers repaired before the failure are still recorded
+			changed = true
+			continue
+		}
+		report.repaired += len(damaged)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return sh.writeHeader()
+}
+
+// scrubs the hub and its replicas, each repairing from the others,
+// and fails with ErrDataLoss when any file could not be repaired
+func (sh* StickerHub) Scrub() error {
+	if err := sh.checkWritable(); err != nil {
+		return err
+	}
+	hubs := append([]*StickerHub{ sh }, sh.replicas...)
+	var report scrubReport
+	for i, h := range(hubs) {
+		others := append(append([]*StickerHub{}, hubs[:i]...), hubs[i + 1:]...)
+		if err := h.scrub(others, &report); err != nil {
+			return fmt.Errorf("scrub \"%s\": %s", h.Name(), err)
+		}
+	}
+	fmt.Printf("Scrubbed %d files in %d stickers across %d hubs: %d damaged, %d repaired, %d files unrepairable\n", report.files, report.stickers, len(hubs), report.damaged, report.repaired, report.lost)
+	if report.lost > 0 {
+		return fmt.Errorf("%d files could not be repaired: %w", report.lost, ErrDataLoss)
+	}
+	return nil
+}
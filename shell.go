@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var shellCommands = []string{ "cat", "cd", "exit", "get", "help", "ls", "put", "pwd", "rm", "stat" }
+
+type lineReader struct {
+	in *bufio.Reader
+	fd int
+	raw bool
+	complete func(line string) (string, []string)
+}
+
+func newLineReader(complete func(line string) (string, []string)) *lineReader {
+	fd := int(os.Stdin.Fd())
+	return &lineReader{
+		in: bufio.NewReader(os.Stdin),
+		fd: fd,
+		raw: isTerminal(fd),
+		complete: complete,
+	}
+}
+
+func (lr *lineReader) readLine(prompt string) (string, error) {
+	if !lr.raw {
+		fmt.Print(prompt)
+		line, err := lr.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	state, err := makeRaw(lr.fd)
+	if err != nil {
+		lr.raw = false
+		return lr.readLine(prompt)
+	}
+	defer restoreTerm(lr.fd, state)
+	var line []rune
+	redraw := func() {
+		fmt.Print("\r\033[K", prompt, string(line))
+	}
+	redraw()
+	for {
+		r, _, err := lr.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Print("\r\n")
+			return string(line), nil
+		case 3:
+			fmt.Print("^C\r\n")
+			return "", nil
+		case 4:
+			if len(line) == 0 {
+				fmt.Print("\r\n")
+				return "", io.EOF
+			}
+		case 8, 127:
+			if len(line) > 0 {
+				line = line[:len(line) - 1]
+			}
+			redraw()
+		case 21:
+			line = line[:0]
+			redraw()
+		case '\t':
+			completed, candidates := lr.complete(string(line))
+			if completed == string(line) && len(candidates) > 1 {
+				fmt.Print("\r\n", strings.Join(candidates, "  "), "\r\n")
+			}
+			line = []rune(completed)
+			redraw()
+		case 27:
+			lr.skipEscape()
+		default:
+			if r >= 32 {
+				line = append(line, r)
+				redraw()
+			}
+		}
+	}
+}
+
+func (lr *lineReader) skipEscape() {
+	b, err := lr.in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return
+	}
+	for {
+		b, err = lr.in.ReadByte()
+		if err != nil || (b >= 0x40 && b <= 0x7E) {
+			return
+		}
+	}
+}
+
+type shell struct {
+	c *Config
+	sh *StickerHub
+	cwd string
+}
+
+func hubKey(filename string) string {
+	return strings.TrimPrefix(path.Clean("/" + filename), "/")
+}
+
+func (s *shell) resolve(arg string) string {
+	if strings.HasPrefix(arg, "/") {
+		return hubKey(arg)
+	}
+	return hubKey(path.Join(s.cwd, arg))
+}
+
+func (s *shell) children(dir string) ([]string, []string) {
+	var files []string
+	var dirs []string
+	seen := map[string]bool{}
+	for _, e := range(s.sh.Files()) {
+		rel := hubKey(e.Filename)
+		if dir != "" {
+			if !strings.HasPrefix(rel, dir + "/") {
+				continue
+			}
+			rel = rel[len(dir) + 1:]
+		}
+		if i := strings.Index(rel, "/"); i >= 0 {
+			if !seen[rel[:i]] {
+				seen[rel[:i]] = true
+				dirs = append(dirs, rel[:i])
+			}
+			continue
+		}
+		files = append(files, rel)
+	}
+	sort.Strings(files)
+	sort.Strings(dirs)
+	return files, dirs
+}
+
+func (s *shell) isDir(dir string) bool {
+	if dir == "" {
+		return true
+	}
+	for _, e := range(s.sh.Files()) {
+		if strings.HasPrefix(hubKey(e.Filename), dir + "/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *shell) findFile(arg string) (int, error) {
+	if _, err := strconv.Atoi(arg); err == nil {
+		return s.sh.FindFile(arg)
+	}
+	key := s.resolve(arg)
+	for i, e := range(s.sh.Files()) {
+		if hubKey(e.Filename) == key {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no file named \"%s\"", key)
+}
+
+func commonPrefix(words []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+	prefix := words[0]
+	for _, w := range(words[1:]) {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix) - 1]
+		}
+	}
+	return prefix
+}
+
+func (s *shell) hubCandidates(word string, dirsOnly bool) []string {
+	dirPart := ""
+	if i := strings.LastIndex(word, "/"); i >= 0 {
+		dirPart = word[:i + 1]
+	}
+	base := word[len(dirPart):]
+	files, dirs := s.children(s.resolve(dirPart))
+	var out []string
+	for _, d := range(dirs) {
+		if strings.HasPrefix(d, base) {
+			out = append(out, dirPart + d + "/")
+		}
+	}
+	if dirsOnly {
+		return out
+	}
+	for _, f := range(files) {
+		if strings.HasPrefix(f, base) {
+			out = append(out, dirPart + f)
+		}
+	}
+	return out
+}
+
+func localCandidates(word string) []string {
+	dir, base := filepath.Split(word)
+	entries, err := os.ReadDir(filepath.Clean("./" + dir))
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, e := range(entries) {
+		if !strings.HasPrefix(e.Name(), base) {
+			continue
+		}
+		if e.IsDir() {
+			out = append(out, dir + e.Name() + "/")
+		} else {
+			out = append(out, dir + e.Name())
+		}
+	}
+	return out
+}
+
+func (s *shell) complete(line string) (string, []string) {
+	fields := strings.Fields(line)
+	word := ""
+	if len(fields) > 0 && !strings.HasSuffix(line, " ") {
+		word = fields[len(fields) - 1]
+		fields = fields[:len(fields) - 1]
+	}
+	prefix := line[:len(line) - len(word)]
+	var candidates []string
+	switch {
+	case len(fields) == 0:
+		for _, c := range(shellCommands) {
+			if strings.HasPrefix(c, word) {
+				candidates = append(candidates, c)
+			}
+		}
+	case fields[0] == "put" && len(fields) == 1:
+		candidates = localCandidates(word)
+	case fields[0] == "cd" || fields[0] == "ls":
+		candidates = s.hubCandidates(word, true)
+	default:
+		candidates = s.hubCandidates(word, false)
+	}
+	switch len(candidates) {
+	case 0:
+		return line, nil
+	case 1:
+		if strings.HasSuffix(candidates[0], "/") {
+			return prefix + candidates[0], candidates
+		}
+		return prefix + candidates[0] + " ", candidates
+	default:
+		return prefix + commonPrefix(candidates), candidates
+	}
+}
+
+func (s *shell) help() {
+	fmt.Println("Commands:")
+	fmt.Println("-", "ls", "[dir]", ":", "list files")
+	fmt.Println("-", "cd", "[dir]", ":", "change directory")
+	fmt.Println("-", "pwd", ":", "print current directory")
+	fmt.Println("-", "put", "<local file> [name]", ":", "put file into hub")
+	fmt.Println("-", "get", "<file> [local file]", ":", "download file from hub")
+	fmt.Println("-", "cat", "<file>", ":", "print file from hub")
+	fmt.Println("-", "rm", "<file>", ":", "remove file from hub")
+	fmt.Println("-", "stat", "<file>", ":", "show file info")
+	fmt.Println("-", "exit", ":", "leave the shell")
+}
+
+func (s *shell) ls(args []string) error {
+	dir := s.cwd
+	if len(args) > 0 {
+		dir = s.resolve(args[0])
+	}
+	if !s.isDir(dir) {
+		return fmt.Errorf("no directory named \"%s\"", dir)
+	}
+	files, dirs := s.children(dir)
+	for _, d := range(dirs) {
+		fmt.Println(d + "/")
+	}
+	for _, f := range(files) {
+		fmt.Println(f)
+	}
+	return nil
+}
+
+func (s *shell) cd(args []string) error {
+	if len(args) == 0 {
+		s.cwd = ""
+		return nil
+	}
+	dir := s.resolve(args[0])
+	if !s.isDir(dir) {
+		return fmt.Errorf("no directory named \"%s\"", dir)
+	}
+	s.cwd = dir
+	return nil
+}
+
+func (s *shell) put(args []string) error {
+	if len(args) < 1 {
+		return errors.New("Usage: put <local file> [name]")
+	}
+	name := s.resolve(filepath.Base(args[0]))
+	if len(args) > 1 {
+		name = s.resolve(args[1])
+	}
+	return s.sh.UploadFile(args[0], name)
+}
+
+func (s *shell) get(args []string) error {
+	if len(args) < 1 {
+		return errors.New("Usage: get <file> [local file]")
+	}
+	index, err := s.findFile(args[0])
+	if err != nil {
+		return err
+	}
+	file, err := s.sh.ReadFile(index)
+	if err != nil {
+		return err
+	}
+	local := path.Base(hubKey(s.sh.GetInfoEntry(index).Filename))
+	if len(args) > 1 {
+		local = args[1]
+	}
+	return os.WriteFile(local, file, 0644)
+}
+
+func (s *shell) cat(args []string) error {
+	if len(args) < 1 {
+		return errors.New("Usage: cat <file>")
+	}
+	index, err := s.findFile(args[0])
+	if err != nil {
+		return err
+	}
+	file, err := s.sh.ReadFile(index)
+	if err != nil {
+		return err
+	}
+	os.Stdout.Write(file)
+	if len(file) > 0 && file[len(file) - 1] != '\n' {
+		fmt.Println()
+	}
+	return nil
+}
+
+func (s *shell) rm(args []string) error {
+	if len(args) < 1 {
+		return errors.New("Usage: rm <file>")
+	}
+	index, err := s.findFile(args[0])
+	if err != nil {
+		return err
+	}
+	return s.sh.RemoveFile(index)
+}
+
+func (s *shell) stat(args []string) error {
+	if len(args) < 1 {
+		return errors.New("Usage: stat <file>")
+	}
+	index, err := s.findFile(args[0])
+	if err != nil {
+		return err
+	}
+	e := s.sh.GetInfoEntry(index)
+	fmt.Println("Name:", e.Filename)
+	fmt.Println("Index:", index + 1)
+	if e.Size > 0 {
+		fmt.Println("Size:", e.Size)
+	} else {
+		fmt.Println("Size:", "unknown")
+	}
+	fmt.Println("Chunks:", len(e.Stickers))
+	if e.Carrier != "" {
+		fmt.Println("Carrier:", e.Carrier)
+	} else {
+		fmt.Println("Carrier:", CarrierAlpha)
+	}
+	if len(e.Hashes) > 0 {
+		fmt.Println("Chunking:", "content-defined")
+	}
+	if e.Parity > 0 {
+		fmt.Println("Parity:", fmt.Sprintf("%d per %d chunks", e.Parity, e.Group))
+	}
+	for _, st := range(s.sh.FileStickers(index)) {
+		fmt.Println("Sticker:", st.FileId)
+	}
+	return nil
+}
+
+func (s *shell) exec(fields []string) (bool, error) {
+	args := fields[1:]
+	switch fields[0] {
+	case "exit", "quit": return true, nil
+	case "help": s.help()
+	case "pwd": fmt.Println("/" + s.cwd)
+	case "ls": return false, s.ls(args)
+	case "cd": return false, s.cd(args)
+	case "put": return false, s.put(args)
+	case "get": return false, s.get(args)
+	case "cat": return false, s.cat(args)
+	case "rm": return false, s.rm(args)
+	case "stat": return false, s.stat(args)
+	default:
+		return false, fmt.Errorf("unknown command \"%s\", try help", fields[0])
+	}
+	return false, nil
+}
+
+func (s *shell) run() error {
+	lr := newLineReader(s.complete)
+	for {
+		line, err := lr.readLine(fmt.Sprintf("%s:/%s> ", s.sh.Title(), s.cwd))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		exit, err := s.exec(fields)
+		if err != nil {
+			fmt.Println(err)
+		}
+		if exit {
+			return nil
+		}
+	}
+}
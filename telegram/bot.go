@@ -0,0 +1,171 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type Bot struct {
+	token string
+	client http.Client
+}
+
+func NewBot(token string) *Bot {
+	return &Bot{ token: token }
+}
+
+func (b *Bot) url(endpoint string) string {
+	return "https://api.telegram.org/bot" + b.token + "/" + endpoint
+}
+
+func (b *Bot) fileUrl(filePath string) string {
+	return "https://api.telegram.org/file/bot" + b.token + "/" + filePath
+}
+
+// keeps the bot token out of error messages
+func (b *Bot) redact(s string) string {
+	if b.token == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, b.token, "<token>")
+}
+
+func (b *Bot) request(url string, method string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("\"%s\" create request: %s", b.redact(url), b.redact(err.Error()))
+	}
+	req.Header = header
+	res, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("\"%s\" make request: %s", b.redact(url), b.redact(err.Error()))
+	}
+	return res, nil
+}
+
+func decodeResponse[T any](res *http.Response) (T, error) {
+	var resData Response[T]
+	defer res.Body.Close()
+	err := json.NewDecoder(res.Body).Decode(&resData)
+	if err != nil {
+		return resData.Result, fmt.Errorf("json decode response: %s", err)
+	}
+	if !resData.Ok || res.StatusCode != 200 {
+		return resData.Result, Error{ Status: res.StatusCode, Desc: resData.Desc }
+	}
+	return resData.Result, nil
+}
+
+// calls a method with params sent as json, or with no params when they are nil
+func call[T any](b *Bot, method string, params any) (T, error) {
+	var res *http.Response
+	var err error
+	if params == nil {
+		res, err = b.request(b.url(method), "GET", nil, nil)
+	} else {
+		var jsonData []byte
+		jsonData, err = json.Marshal(params)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		res, err = b.request(b.url(method), "POST", bytes.NewReader(jsonData), http.Header{
+			"Content-Type": []string{ "application/json" },
+		})
+	}
+	if err != nil {
+		var zero T
+		return zero, fmt.Errorf("fetch: %s", err)
+	}
+	return decodeResponse[T](res)
+}
+
+type upload struct {
+	field string
+	filename string
+	data []byte
+}
+
+// calls a method that takes a file, sending fields and the file as multipart form
+func callUpload[T any](b *Bot, method string, fields map[string]string, file upload) (T, error) {
+	var zero T
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, value := range(fields) {
+		if err := w.WriteField(name, value); err != nil {
+			return zero, err
+		}
+	}
+	fw, err := w.CreateFormFile(file.field, file.filename)
+	if err != nil {
+		return zero, err
+	}
+	if _, err = fw.Write(file.data); err != nil {
+		return zero, err
+	}
+	if err = w.Close(); err != nil {
+		return zero, err
+	}
+	res, err := b.request(b.url(method), "POST", &body, http.Header{
+		"Content-Type": []string{ w.FormDataContentType() },
+	})
+	if err != nil {
+		return zero, fmt.Errorf("fetch: %s", err)
+	}
+	return decodeResponse[T](res)
+}
+
+func (b *Bot) GetMe() (User, error) {
+	return call[User](b, "getMe", nil)
+}
+
+type ParamsGetChat struct {
+	ChatId int `json:"chat_id"`
+}
+
+func (b *Bot) GetChat(chatId int) (Chat, error) {
+	return call[Chat](b, "getChat", ParamsGetChat{ ChatId: chatId })
+}
+
+type ParamsGetFile struct {
+	FileId string `json:"file_id"`
+}
+
+func (b *Bot) GetFile(fileId string) (File, error) {
+	return call[File](b, "getFile", ParamsGetFile{ FileId: fileId })
+}
+
+func (b *Bot) DownloadFile(file File) ([]byte, error) {
+	res, err := b.request(b.fileUrl(file.Path), "GET", nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, Error{ Status: res.StatusCode, Desc: "file download failed" }
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read body: %s", err)
+	}
+	return body, nil
+}
+
+// looks the file up and downloads it
+func (b *Bot) GetFileData(fileId string) ([]byte, error) {
+	file, err := b.GetFile(fileId)
+	if err != nil {
+		return nil, err
+	}
+	return b.DownloadFile(file)
+}
+
+func formatUserId(userId int) string {
+	return strconv.Itoa(userId)
+}
@@ -0,0 +1,134 @@
+package telegram
+
+type ParamsGetStickerSet struct {
+	Name string `json:"name"`
+}
+
+func (b *Bot) GetStickerSet(name string) (StickerSet, error) {
+	return call[StickerSet](b, "getStickerSet", ParamsGetStickerSet{ Name: name })
+}
+
+type ParamsGetCustomEmojiStickers struct {
+	CustomEmojiIds []string `json:"custom_emoji_ids"`
+}
+
+func (b *Bot) GetCustomEmojiStickers(customEmojiIds []string) ([]Sticker, error) {
+	return call[[]Sticker](b, "getCustomEmojiStickers", ParamsGetCustomEmojiStickers{ CustomEmojiIds: customEmojiIds })
+}
+
+// format is "static", "animated" or "video"
+func (b *Bot) UploadStickerFile(userId int, format string, filename string, data []byte) (File, error) {
+	return callUpload[File](b, "uploadStickerFile", map[string]string{
+		"user_id": formatUserId(userId),
+		"sticker_format": format,
+	}, upload{ field: "sticker", filename: filename, data: data })
+}
+
+type ParamsCreateNewStickerSet struct {
+	UserId int `json:"user_id"`
+	Name string `json:"name"`
+	Title string `json:"title"`
+	Stickers []InputSticker `json:"stickers"`
+	// "regular" when empty, "mask" or "custom_emoji"
+	StickerType string `json:"sticker_type,omitempty"`
+	NeedsRepainting bool `json:"needs_repainting,omitempty"`
+}
+
+func (b *Bot) CreateNewStickerSet(params ParamsCreateNewStickerSet) (bool, error) {
+	return call[bool](b, "createNewStickerSet", params)
+}
+
+type ParamsAddStickerToSet struct {
+	UserId int `json:"user_id"`
+	Name string `json:"name"`
+	Sticker InputSticker `json:"sticker"`
+}
+
+func (b *Bot) AddStickerToSet(params ParamsAddStickerToSet) (bool, error) {
+	return call[bool](b, "addStickerToSet", params)
+}
+
+type ParamsReplaceStickerInSet struct {
+	UserId int `json:"user_id"`
+	Name string `json:"name"`
+	OldSticker string `json:"old_sticker"`
+	Sticker InputSticker `json:"sticker"`
+}
+
+func (b *Bot) ReplaceStickerInSet(params ParamsReplaceStickerInSet) (bool, error) {
+	return call[bool](b, "replaceStickerInSet", params)
+}
+
+type ParamsSticker struct {
+	Sticker string `json:"sticker"`
+}
+
+func (b *Bot) DeleteStickerFromSet(fileId string) (bool, error) {
+	return call[bool](b, "deleteStickerFromSet", ParamsSticker{ Sticker: fileId })
+}
+
+type ParamsSetStickerPositionInSet struct {
+	Sticker string `json:"sticker"`
+	Position int `json:"position"`
+}
+
+// position is zero-based
+func (b *Bot) SetStickerPositionInSet(fileId string, position int) (bool, error) {
+	return call[bool](b, "setStickerPositionInSet", ParamsSetStickerPositionInSet{ Sticker: fileId, Position: position })
+}
+
+type ParamsSetStickerEmojiList struct {
+	Sticker string `json:"sticker"`
+	EmojiList []string `json:"emoji_list"`
+}
+
+func (b *Bot) SetStickerEmojiList(fileId string, emojiList []string) (bool, error) {
+	return call[bool](b, "setStickerEmojiList", ParamsSetStickerEmojiList{ Sticker: fileId, EmojiList: emojiList })
+}
+
+type ParamsSetStickerKeywords struct {
+	Sticker string `json:"sticker"`
+	Keywords []string `json:"keywords"`
+}
+
+func (b *Bot) SetStickerKeywords(fileId string, keywords []string) (bool, error) {
+	return call[bool](b, "setStickerKeywords", ParamsSetStickerKeywords{ Sticker: fileId, Keywords: keywords })
+}
+
+type ParamsSetStickerSetTitle struct {
+	Name string `json:"name"`
+	Title string `json:"title"`
+}
+
+func (b *Bot) SetStickerSetTitle(name string, title string) (bool, error) {
+	return call[bool](b, "setStickerSetTitle", ParamsSetStickerSetTitle{ Name: name, Title: title })
+}
+
+type ParamsSetStickerSetThumbnail struct {
+	Name string `json:"name"`
+	UserId int `json:"user_id"`
+	// file_id or URL, empty to drop the thumbnail and use the first sticker
+	Thumbnail string `json:"thumbnail,omitempty"`
+	Format string `json:"format"`
+}
+
+func (b *Bot) SetStickerSetThumbnail(params ParamsSetStickerSetThumbnail) (bool, error) {
+	return call[bool](b, "setStickerSetThumbnail", params)
+}
+
+// uploads the thumbnail instead of referring to an existing file
+func (b *Bot) UploadStickerSetThumbnail(name string, userId int, format string, filename string, data []byte) (bool, error) {
+	return callUpload[bool](b, "setStickerSetThumbnail", map[string]string{
+		"name": name,
+		"user_id": formatUserId(userId),
+		"format": format,
+	}, upload{ field: "thumbnail", filename: filename, data: data })
+}
+
+type ParamsName struct {
+	Name string `json:"name"`
+}
+
+func (b *Bot) DeleteStickerSet(name string) (bool, error) {
+	return call[bool](b, "deleteStickerSet", ParamsName{ Name: name })
+}
@@ -0,0 +1,86 @@
+package telegram
+
+import (
+	"fmt"
+)
+
+type Response[T any] struct {
+	Ok bool `json:"ok"`
+	Result T `json:"result"`
+	Desc string `json:"description"`
+}
+
+type Error struct {
+	Status int
+	Desc string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("response is not OK: status is %d, desc is %s", e.Status, e.Desc)
+}
+
+type Chat struct {
+	Id int `json:"id"`
+	Type string `json:"type"`
+	Username string `json:"username"`
+	FirstName string `json:"first_name"`
+}
+
+type File struct {
+	Id string `json:"file_id"`
+	UniqueId string `json:"file_unique_id"`
+	Path string `json:"file_path"`
+	Size int `json:"file_size"`
+}
+
+type User struct {
+	Id int `json:"id"`
+	IsBot bool `json:"is_bot"`
+	FirstName string `json:"first_name"`
+	Username string `json:"username"`
+}
+
+type PhotoSize struct {
+	FileId string `json:"file_id"`
+	UniqueId string `json:"file_unique_id"`
+	Width int `json:"width"`
+	Height int `json:"height"`
+	FileSize int `json:"file_size"`
+}
+
+type Sticker struct {
+	FileId string `json:"file_id"`
+	UniqueId string `json:"file_unique_id"`
+	// "regular", "mask" or "custom_emoji"
+	Type string `json:"type"`
+	Width int `json:"width"`
+	Height int `json:"height"`
+	IsAnimated bool `json:"is_animated"`
+	IsVideo bool `json:"is_video"`
+	Thumbnail *PhotoSize `json:"thumbnail,omitempty"`
+	// first emoji of the list
+	Emoji string `json:"emoji"`
+	// empty unless getStickerSet returns them, which it does not so far
+	Keywords []string `json:"keywords,omitempty"`
+	SetName string `json:"set_name"`
+	CustomEmojiId string `json:"custom_emoji_id"`
+	NeedsRepainting bool `json:"needs_repainting"`
+	FileSize int `json:"file_size"`
+}
+
+type StickerSet struct {
+	Name string `json:"name"`
+	Title string `json:"title"`
+	StickerType string `json:"sticker_type"`
+	Stickers []Sticker `json:"stickers"`
+	Thumbnail *PhotoSize `json:"thumbnail,omitempty"`
+}
+
+type InputSticker struct {
+	// file_id of an uploaded sticker file, or an URL
+	Sticker string `json:"sticker"`
+	// "static", "animated" or "video"
+	Format string `json:"format"`
+	EmojiList []string `json:"emoji_list"`
+	Keywords []string `json:"keywords,omitempty"`
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var ErrNoToken = errors.New("no bot token: export " + DefaultTokenEnv + " or run login")
+
+// Token sources in order of precedence, explicit settings of the profile first:
+// 1. environment variable named by TokenEnv
+// 2. stdout of the TokenCommand credential helper
+// 3. TokenFile, which must not be accessible by group or others
+// 4. Token stored in the config itself
+// 5. TOKEN environment variable, for profiles without any of the above
+func resolveToken(p *HubProfile) (string, error) {
+	if p.TokenEnv != "" {
+		if token := os.Getenv(p.TokenEnv); token != "" {
+			return token, nil
+		}
+	}
+	if p.TokenCommand != "" {
+		token, err := runTokenCommand(p.TokenCommand)
+		if err != nil {
+			return "", fmt.Errorf("token command: %s", err)
+		}
+		return token, nil
+	}
+	if p.TokenFile != "" {
+		token, err := readTokenFile(p.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("token file: %s", err)
+		}
+		return token, nil
+	}
+	if p.Token != "" {
+		return p.Token, nil
+	}
+	if p.TokenEnv == "" {
+		if token := os.Getenv(DefaultTokenEnv); token != "" {
+			return token, nil
+		}
+	}
+	return "", ErrNoToken
+}
+
+func runTokenCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return "", errors.New("command printed nothing")
+	}
+	return token, nil
+}
+
+func readTokenFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() & 0077 != 0 {
+		return "", fmt.Errorf("%s is accessible by other users, run chmod 600 on it", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return token, nil
+}
+
+func writeTokenFile(path string, token string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(token + "\n"), 0600)
+}
+
+// hub names become file names, so they must not be able to point elsewhere
+func checkHubName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, "-") {
+		return fmt.Errorf("invalid hub name \"%s\": use a name without slashes that does not start with -", name)
+	}
+	return nil
+}
+
+func defaultTokenFile(configPath string, hubName string) (string, error) {
+	if err := checkHubName(hubName); err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(configPath), "tokens", hubName), nil
+}
+
+func readSecret(prompt string) (string, error) {
+	fmt.Print(prompt)
+	fd := int(os.Stdin.Fd())
+	if !isTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimSpace(line), nil
+	}
+	state, err := makeRaw(fd)
+	if err != nil {
+		return "", err
+	}
+	defer restoreTerm(fd, state)
+	var secret []byte
+	buf := make([]byte, 1)
+	for {
+		_, err := os.Stdin.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		switch buf[0] {
+		case '\r', '\n':
+			fmt.Print("\r\n")
+			return strings.TrimSpace(string(secret)), nil
+		case 3:
+			fmt.Print("\r\n")
+			return "", errors.New("interrupted")
+		case 8, 127:
+			if len(secret) > 0 {
+				secret = secret[:len(secret) - 1]
+			}
+		default:
+			secret = append(secret, buf[0])
+		}
+	}
+}
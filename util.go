@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"github.com/google/uuid"
 	"strings"
 )
@@ -14,14 +13,6 @@ func printBytesHex(data []byte) {
 	fmt.Println()
 }
 
-func getToken() string {
-	token := os.Getenv("TOKEN")
-	if token == "" {
-		panic("Provide token in env")
-	}
-	return token
-}
-
 func hiword(n uint16) byte {
 	return byte(n >> 8)
 }
@@ -34,12 +25,9 @@ func generateNewSetName(username string) string {
 	return "stickerhub_" + strings.ReplaceAll(uuid.New().String(), "-", "_") + "_by_" + username
 }
 
-func getFileData(fileId string) ([]byte, error) {
-	file, err := getFile(fileId)
-	if err != nil {
-		return nil, err
-	}
-	return downloadFile(file)
+// short enough to fit into sticker keywords
+func generateFileId() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")[:8]
 }
 
 func promptBool(message string, retryCount int) (bool, error) {
@@ -66,7 +54,7 @@ func promptString(message string) string {
 func promptInt(message string, retryCount int) (int, error) {
 	var out int
 	var err error
-	fmt.Printf(message)
+	fmt.Print(message)
 	for range(retryCount) {
 		_, err = fmt.Scanln(&out)
 		if err == nil {
@@ -75,3 +63,24 @@ func promptInt(message string, retryCount int) (int, error) {
 	}
 	return out, fmt.Errorf("failed to prompt: %s", err)
 }
+
+func extractFlag(argv []string, name string) ([]string, string, bool) {
+	rest := make([]string, 0, len(argv))
+	var value string
+	var found bool
+	for i := 0; i < len(argv); i++ {
+		if argv[i] == name && i + 1 < len(argv) {
+			value = argv[i + 1]
+			found = true
+			i++
+			continue
+		}
+		if strings.HasPrefix(argv[i], name + "=") {
+			value = strings.TrimPrefix(argv[i], name + "=")
+			found = true
+			continue
+		}
+		rest = append(rest, argv[i])
+	}
+	return rest, value, found
+}
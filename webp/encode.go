@@ -0,0 +1,489 @@
+package webp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"slices"
+	"sort"
+)
+
+const (
+	vp8lSignature byte = 0x2f
+	vp8lMaxSide = 1 << 14
+	vp8lTransformSubtractGreen = 2
+	vp8lMaxCodeLength = 15
+	vp8lMaxCodeLengthCodeLength = 7
+	vp8lLiteralCount = 256
+	vp8lLengthCodeCount = 24
+	vp8lDistanceCodeCount = 40
+	vp8lCodeLengthCodeCount = 19
+	// distance codes up to this one address neighbouring pixels in 2D
+	vp8lPlaneCodes = 120
+	vp8lMinMatch = 3
+	vp8lMaxMatch = 4096
+	vp8lMaxDistance = 1 << 20 - vp8lPlaneCodes
+	vp8lHashBits = 16
+	vp8lChainDepth = 32
+)
+
+var vp8lCodeLengthCodeOrder = [vp8lCodeLengthCodeCount]int{ 17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15 }
+
+// bits are packed starting from the least significant one
+type bitWriter struct {
+	buf []byte
+	acc uint64
+	n uint
+}
+
+func (bw *bitWriter) write(v uint32, n uint) {
+	bw.acc |= uint64(v) << bw.n
+	bw.n += n
+	for bw.n >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.n -= 8
+	}
+}
+
+func (bw *bitWriter) bytes() []byte {
+	if bw.n > 0 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc = 0
+		bw.n = 0
+	}
+	return bw.buf
+}
+
+// optimal code lengths for the histogram, without the length limit
+func buildLengths(freq []int) []int {
+	lengths := make([]int, len(freq))
+	var leaves []int
+	for s, f := range(freq) {
+		if f > 0 {
+			leaves = append(leaves, s)
+		}
+	}
+	if len(leaves) == 0 {
+		return lengths
+	}
+	if len(leaves) == 1 {
+		lengths[leaves[0]] = 1
+		return lengths
+	}
+	sort.SliceStable(leaves, func(i, j int) bool { return freq[leaves[i]] < freq[leaves[j]] })
+	// leaves come first, internal nodes follow in the order they are created,
+	// so both are already sorted by weight and every parent comes after its children
+	weight := make([]int, 0, len(leaves) * 2 - 1)
+	parent := make([]int, len(leaves) * 2 - 1)
+	for _, s := range(leaves) {
+		weight = append(weight, freq[s])
+	}
+	li := 0
+	ni := len(leaves)
+	pick := func() int {
+		if li < len(leaves) && (ni >= len(weight) || weight[li] <= weight[ni]) {
+			li++
+			return li - 1
+		}
+		ni++
+		return ni - 1
+	}
+	for len(weight) < cap(weight) {
+		a := pick()
+		b := pick()
+		parent[a] = len(weight)
+		parent[b] = len(weight)
+		weight = append(weight, weight[a] + weight[b])
+	}
+	depth := make([]int, len(weight))
+	for i := len(weight) - 2; i >= 0; i-- {
+		depth[i] = depth[parent[i]] + 1
+	}
+	for i, s := range(leaves) {
+		lengths[s] = depth[i]
+	}
+	return lengths
+}
+
+// flattens the histogram until no code is longer than maxLength
+func huffmanLengths(freq []int, maxLength int) []int {
+	f := slices.Clone(freq)
+	for {
+		lengths := buildLengths(f)
+		if slices.Max(lengths) <= maxLength {
+			return lengths
+		}
+		for i := range(f) {
+			if f[i] > 1 {
+				f[i] = (f[i] + 1) / 2
+			}
+		}
+	}
+}
+
+// canonical codes, bit-reversed since the decoder reads them from the most significant bit
+func canonicalCodes(lengths []int) []uint32 {
+	var count [vp8lMaxCodeLength + 1]uint32
+	for _, l := range(lengths) {
+		if l > 0 {
+			count[l]++
+		}
+	}
+	var next [vp8lMaxCodeLength + 1]uint32
+	code := uint32(0)
+	for l := 1; l <= vp8lMaxCodeLength; l++ {
+		code = (code + count[l - 1]) << 1
+		next[l] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for s, l := range(lengths) {
+		if l > 0 {
+			codes[s] = bits.Reverse32(next[l]) >> (32 - l)
+			next[l]++
+		}
+	}
+	return codes
+}
+
+type prefixCode struct {
+	lengths []int
+	codes []uint32
+}
+
+// a code with a single symbol takes no bits in the stream
+func newPrefixCode(lengths []int, used int) prefixCode {
+	if used == 1 {
+		return prefixCode{ lengths: make([]int, len(lengths)), codes: make([]uint32, len(lengths)) }
+	}
+	return prefixCode{ lengths: lengths, codes: canonicalCodes(lengths) }
+}
+
+func (pc prefixCode) write(bw *bitWriter, symbol int) {
+	bw.write(pc.codes[symbol], uint(pc.lengths[symbol]))
+}
+
+type codeLengthToken struct {
+	symbol int
+	extra uint32
+}
+
+// 16 repeats the previous length 3-6 times, 17 and 18 repeat zero 3-10 and 11-138 times
+func rleCodeLengths(lengths []int) []codeLengthToken {
+	var out []codeLengthToken
+	for i := 0; i < len(lengths); {
+		l := lengths[i]
+		run := 1
+		for i + run < len(lengths) && lengths[i + run] == l {
+			run++
+		}
+		i += run
+		if l == 0 {
+			for run >= 11 {
+				n := min(run, 138)
+				out = append(out, codeLengthToken{ 18, uint32(n - 11) })
+				run -= n
+			}
+			if run >= 3 {
+				out = append(out, codeLengthToken{ 17, uint32(run - 3) })
+				run = 0
+			}
+		} else {
+			out = append(out, codeLengthToken{ l, 0 })
+			run--
+			for run >= 3 {
+				n := min(run, 6)
+				out = append(out, codeLengthToken{ 16, uint32(n - 3) })
+				run -= n
+			}
+		}
+		for range(run) {
+			out = append(out, codeLengthToken{ l, 0 })
+		}
+	}
+	return out
+}
+
+func writeCodeLengths(bw *bitWriter, lengths []int) {
+	tokens := rleCodeLengths(lengths)
+	freq := make([]int, vp8lCodeLengthCodeCount)
+	for _, t := range(tokens) {
+		freq[t.symbol]++
+	}
+	clLengths := huffmanLengths(freq, vp8lMaxCodeLengthCodeLength)
+	n := vp8lCodeLengthCodeCount
+	for n > 4 && clLengths[vp8lCodeLengthCodeOrder[n - 1]] == 0 {
+		n--
+	}
+	bw.write(uint32(n - 4), 4)
+	for i := range(n) {
+		bw.write(uint32(clLengths[vp8lCodeLengthCodeOrder[i]]), 3)
+	}
+	// lengths are given for the whole alphabet
+	bw.write(0, 1)
+	used := 0
+	for _, l := range(clLengths) {
+		if l > 0 {
+			used++
+		}
+	}
+	pc := newPrefixCode(clLengths, used)
+	for _, t := range(tokens) {
+		pc.write(bw, t.symbol)
+		switch t.symbol {
+		case 16: bw.write(t.extra, 2)
+		case 17: bw.write(t.extra, 3)
+		case 18: bw.write(t.extra, 7)
+		}
+	}
+}
+
+// writes a prefix code for the histogram and returns it for encoding symbols
+func writePrefixCode(bw *bitWriter, freq []int) prefixCode {
+	lengths := huffmanLengths(freq, vp8lMaxCodeLength)
+	var used []int
+	for s, l := range(lengths) {
+		if l > 0 {
+			used = append(used, s)
+		}
+	}
+	if len(used) == 0 {
+		// unused alphabets still need a code
+		lengths[0] = 1
+		used = []int{ 0 }
+	}
+	if len(used) <= 2 && used[len(used) - 1] < vp8lLiteralCount {
+		bw.write(1, 1)
+		bw.write(uint32(len(used) - 1), 1)
+		if used[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(used[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			bw.write(uint32(used[1]), 8)
+		}
+	} else {
+		bw.write(0, 1)
+		writeCodeLengths(bw, lengths)
+	}
+	return newPrefixCode(lengths, len(used))
+}
+
+// splits a length or distance into its prefix symbol and extra bits
+func prefixEncode(v int) (int, uint, uint32) {
+	if v <= 4 {
+		return v - 1, 0, 0
+	}
+	v--
+	hb := bits.Len(uint(v)) - 1
+	second := (v >> (hb - 1)) & 1
+	extraBits := uint(hb - 1)
+	return hb * 2 + second, extraBits, uint32(v) & (1 << extraBits - 1)
+}
+
+// literal pixel when length is 0, backward reference otherwise
+type vp8lToken struct {
+	argb uint32
+	length int
+	dist int
+}
+
+func pixelHash(pix []uint32, i int) uint32 {
+	return (pix[i] * 0x1e35a7bd ^ pix[i + 1] * 0x9e3779b1 ^ pix[i + 2] * 0x85ebca6b) >> (32 - vp8lHashBits)
+}
+
+// greedy LZ77 over pixels with hash chains
+func findMatches(pix []uint32) []vp8lToken {
+	head := make([]int32, 1 << vp8lHashBits)
+	for i := range(head) {
+		head[i] = -1
+	}
+	prev := make([]int32, len(pix))
+	insert := func(i int) {
+		if i + vp8lMinMatch <= len(pix) {
+			h := pixelHash(pix, i)
+			prev[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+	var tokens []vp8lToken
+	for i := 0; i < len(pix); {
+		bestLen := 0
+		bestDist := 0
+		if i + vp8lMinMatch <= len(pix) {
+			limit := min(vp8lMaxMatch, len(pix) - i)
+			cand := head[pixelHash(pix, i)]
+			for depth := 0; cand >= 0 && depth < vp8lChainDepth && i - int(cand) <= vp8lMaxDistance; depth++ {
+				c := int(cand)
+				n := 0
+				for n < limit && pix[c + n] == pix[i + n] {
+					n++
+				}
+				if n > bestLen {
+					bestLen = n
+					bestDist = i - c
+					if n == limit {
+						break
+					}
+				}
+				cand = prev[c]
+			}
+		}
+		if bestLen < vp8lMinMatch {
+			tokens = append(tokens, vp8lToken{ argb: pix[i] })
+			insert(i)
+			i++
+			continue
+		}
+		tokens = append(tokens, vp8lToken{ length: bestLen, dist: bestDist })
+		for j := range(bestLen) {
+			insert(i + j)
+		}
+		i += bestLen
+	}
+	return tokens
+}
+
+func writeImageData(bw *bitWriter, tokens []vp8lToken) {
+	green := make([]int, vp8lLiteralCount + vp8lLengthCodeCount)
+	red := make([]int, vp8lLiteralCount)
+	blue := make([]int, vp8lLiteralCount)
+	alpha := make([]int, vp8lLiteralCount)
+	dist := make([]int, vp8lDistanceCodeCount)
+	for _, t := range(tokens) {
+		if t.length == 0 {
+			green[t.argb >> 8 & 0xFF]++
+			red[t.argb >> 16 & 0xFF]++
+			blue[t.argb & 0xFF]++
+			alpha[t.argb >> 24]++
+			continue
+		}
+		s, _, _ := prefixEncode(t.length)
+		green[vp8lLiteralCount + s]++
+		s, _, _ = prefixEncode(t.dist + vp8lPlaneCodes)
+		dist[s]++
+	}
+	greenCode := writePrefixCode(bw, green)
+	redCode := writePrefixCode(bw, red)
+	blueCode := writePrefixCode(bw, blue)
+	alphaCode := writePrefixCode(bw, alpha)
+	distCode := writePrefixCode(bw, dist)
+	for _, t := range(tokens) {
+		if t.length == 0 {
+			greenCode.write(bw, int(t.argb >> 8 & 0xFF))
+			redCode.write(bw, int(t.argb >> 16 & 0xFF))
+			blueCode.write(bw, int(t.argb & 0xFF))
+			alphaCode.write(bw, int(t.argb >> 24))
+			continue
+		}
+		s, n, extra := prefixEncode(t.length)
+		greenCode.write(bw, vp8lLiteralCount + s)
+		bw.write(extra, n)
+		s, n, extra = prefixEncode(t.dist + vp8lPlaneCodes)
+		distCode.write(bw, s)
+		bw.write(extra, n)
+	}
+}
+
+// non-premultiplied pixels packed as ARGB, and whether any of them is not opaque
+func argbPixels(img image.Image) ([]uint32, bool) {
+	b := img.Bounds()
+	pix := make([]uint32, 0, b.Dx() * b.Dy())
+	transparent := false
+	nrgba, isNRGBA := img.(*image.NRGBA)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var c color.NRGBA
+			if isNRGBA {
+				c = nrgba.NRGBAAt(x, y)
+			} else {
+				c = color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			}
+			if c.A != 0xFF {
+				transparent = true
+			}
+			pix = append(pix, uint32(c.A) << 24 | uint32(c.R) << 16 | uint32(c.G) << 8 | uint32(c.B))
+		}
+	}
+	return pix, transparent
+}
+
+// red and blue are stored as differences from green
+func subtractGreen(pix []uint32) {
+	for i, p := range(pix) {
+		g := p >> 8 & 0xFF
+		r := (p >> 16 - g) & 0xFF
+		b := (p - g) & 0xFF
+		pix[i] = p & 0xFF00FF00 | r << 16 | b
+	}
+}
+
+func riffContainer(vp8l []byte) []byte {
+	size := len(vp8l)
+	padded := size + size & 1
+	out := make([]byte, 0, 20 + padded)
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(12 + padded))
+	out = append(out, "WEBPVP8L"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(size))
+	out = append(out, vp8l...)
+	if size & 1 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+func literalTokens(pix []uint32) []vp8lToken {
+	tokens := make([]vp8lToken, len(pix))
+	for i, p := range(pix) {
+		tokens[i].argb = p
+	}
+	return tokens
+}
+
+func encodeTokens(w, h int, transparent bool, tokens []vp8lToken) []byte {
+	var bw bitWriter
+	bw.write(uint32(vp8lSignature), 8)
+	bw.write(uint32(w - 1), 14)
+	bw.write(uint32(h - 1), 14)
+	if transparent {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	// version
+	bw.write(0, 3)
+	bw.write(1, 1)
+	bw.write(vp8lTransformSubtractGreen, 2)
+	bw.write(0, 1)
+	// no color cache, one prefix code group for the whole image
+	bw.write(0, 1)
+	bw.write(0, 1)
+	writeImageData(&bw, tokens)
+	return bw.bytes()
+}
+
+// encodes the image losslessly (VP8L)
+func Encode(img image.Image) ([]byte, error) {
+	b := img.Bounds()
+	w := b.Dx()
+	h := b.Dy()
+	if w < 1 || h < 1 || w > vp8lMaxSide || h > vp8lMaxSide {
+		return nil, fmt.Errorf("image size %dx%d is out of range", w, h)
+	}
+	pix, transparent := argbPixels(img)
+	subtractGreen(pix)
+	best := encodeTokens(w, h, transparent, findMatches(pix))
+	// on noisy images the few matches found cost more than they save,
+	// since they give literals an extra bit in the green code
+	literal := encodeTokens(w, h, transparent, literalTokens(pix))
+	if len(literal) < len(best) {
+		best = literal
+	}
+	return riffContainer(best), nil
+}
@@ -0,0 +1,71 @@
+package webp
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+)
+
+func roundTrip(t *testing.T, img *image.NRGBA) {
+	t.Helper()
+	data, err := Encode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Rect != img.Rect {
+		t.Fatalf("bounds %v, want %v", decoded.Rect, img.Rect)
+	}
+	for i := 0; i < len(img.Pix); i += 4 {
+		// color of fully transparent pixels is not kept
+		if img.Pix[i + 3] == 0 && decoded.Pix[i + 3] == 0 {
+			continue
+		}
+		if [4]byte(decoded.Pix[i:i + 4]) != [4]byte(img.Pix[i:i + 4]) {
+			t.Fatalf("pixel %d is %v, want %v", i / 4, decoded.Pix[i:i + 4], img.Pix[i:i + 4])
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	sizes := [][2]int{ { 1, 1 }, { 3, 7 }, { 512, 3 }, { 100, 100 }, { 512, 512 } }
+	for _, size := range(sizes) {
+		random := image.NewNRGBA(image.Rect(0, 0, size[0], size[1]))
+		r.Read(random.Pix)
+		roundTrip(t, random)
+
+		// alpha only, as carriers write it
+		alpha := image.NewNRGBA(image.Rect(0, 0, size[0], size[1]))
+		for i := 3; i < len(alpha.Pix); i += 4 {
+			alpha.Pix[i] = byte(r.Intn(256))
+		}
+		roundTrip(t, alpha)
+
+		// repetitive, so that backward references are used
+		pattern := image.NewNRGBA(image.Rect(0, 0, size[0], size[1]))
+		for i := range(pattern.Pix) {
+			pattern.Pix[i] = byte(i % 7 * 31)
+		}
+		roundTrip(t, pattern)
+	}
+}
+
+// incompressible payloads must not grow much beyond their size
+func TestRandomOverhead(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 512, 512))
+	r := rand.New(rand.NewSource(2))
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = byte(r.Intn(256))
+	}
+	data, err := Encode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload := 512 * 512; len(data) > payload + payload / 20 {
+		t.Errorf("%d bytes for %d bytes of alpha", len(data), payload)
+	}
+}